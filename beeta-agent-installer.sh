@@ -39,16 +39,14 @@ check_for_agent() {
     read -r -p "Proceeding with the installation will cause REMOVAL of the existing contents of beeta-agent! Do you want to proceed? y/n: " RESPONSE
     if [ "$RESPONSE" = "y" ] || [ "$RESPONSE" = "yes" ]; then
       log Proceeding with the removal of existing beeta-agent contents ...
-      if [ -f "$BEETA_AGENT_DIR/known_manifests.jsonl" ]; then
-        # preserve the contents of known_manifests.jsonl
-        sudo mv "$BEETA_AGENT_DIR/known_manifests.jsonl" /tmp/known_manifests.jsonl
-        cleanup
-        # restore the contents of known_manifests.jsonl
-        mkdir -p "$BEETA_AGENT_DIR"
-        sudo mv /tmp/known_manifests.jsonl "$BEETA_AGENT_DIR/known_manifests.jsonl"
-      else
-        cleanup
-      fi
+      # the state of an agent that ran in the beeta-agent directory is moved into the data directory by the agent on startup
+      sudo mkdir -p "$DATA_DIR"
+      for FILE in known_manifests.jsonl staged_manifests.jsonl beeta_Agent.log; do
+        if [ -f "$BEETA_AGENT_DIR/$FILE" ]; then
+          sudo mv "$BEETA_AGENT_DIR/$FILE" "$DATA_DIR/$FILE"
+        fi
+      done
+      cleanup
     else
       log exiting ...
       exit 0
@@ -95,7 +93,9 @@ build_test_binary() {
 }
 
 copy_dependencies() {
-  cp beeta-agent.service ca.crt "$BEETA_AGENT_DIR"
+  cp beeta-agent.service "$BEETA_AGENT_DIR"
+  sudo mkdir -p "$DATA_DIR/certs"
+  sudo cp ca.crt "$DATA_DIR/certs/ca.crt"
 }
 
 download_binary() {
@@ -155,7 +155,8 @@ download_dependencies() {
   log Downloading the dependencies ...
   if RESULT=$(cd "$BEETA_AGENT_DIR" &&
     wget http://"$S3_BUCKET".s3.amazonaws.com/beeta-agent.service 2>&1 &&
-    wget https://"$BEETA_URL"/public/mqtt-ca -O ca.crt 2>&1); then
+    sudo mkdir -p "$DATA_DIR/certs" &&
+    sudo wget https://"$BEETA_URL"/public/mqtt-ca -O "$DATA_DIR/certs/ca.crt" 2>&1); then
     log Dependencies downloaded
   else
     log Error while downloading the dependencies: "$RESULT"
@@ -170,7 +171,7 @@ write_to_service() {
   # following are the example for the lines appended to beeta-agent.service
 
   BINARY_PATH="$BEETA_AGENT_DIR/$BINARY_NAME"
-  ARGUMENTS="--out --config $CONFIG_FILE"
+  ARGUMENTS="--out --config $CONFIG_FILE --data-dir $DATA_DIR"
 
   # remove hardcoded parameters
   sed -i '/ConditionPathExists\|WorkingDirectory\|ExecStart/d' "$BEETA_AGENT_DIR"/beeta-agent.service
@@ -178,7 +179,7 @@ write_to_service() {
   # the CLI arguments for beeta agent
   if [ -n "$BROKER" ]; then
     ARGUMENTS="$ARGUMENTS --broker $BROKER"
-    echo "$BROKER" | grep -q 'tls://' && ARGUMENTS="$ARGUMENTS --rootcert $BEETA_AGENT_DIR/ca.crt" || ARGUMENTS="$ARGUMENTS --notls"
+    echo "$BROKER" | grep -q 'tls://' && ARGUMENTS="$ARGUMENTS --rootcert $DATA_DIR/certs/ca.crt" || ARGUMENTS="$ARGUMENTS --notls"
   fi
 
   if [ -n "$LOG_LEVEL" ]; then
@@ -192,15 +193,16 @@ write_to_service() {
 
   log Adding the binary path to service file ...
   {
-    printf "WorkingDirectory=%s\n" "$BEETA_AGENT_DIR"
+    printf "WorkingDirectory=%s\n" "$DATA_DIR"
     printf "ExecStart=%s" "$EXECUTE_BINARY"
   } >>"$BEETA_AGENT_DIR"/beeta-agent.service
 }
 
 execute_binary() {
   log Starting the agent binary ...
-  cd "$BEETA_AGENT_DIR"
-  eval "$EXECUTE_BINARY"
+  sudo mkdir -p "$DATA_DIR"
+  cd "$DATA_DIR"
+  eval sudo "$EXECUTE_BINARY"
 }
 
 start_service() {
@@ -224,7 +226,7 @@ start_service() {
 tail_agent_log() {
   # parsing the beeta-agent log to verify if the beeta-agent is registered and connected
   log tailing the beeta-agent logs
-  timeout 10s tail -f "$BEETA_AGENT_DIR"/beeta_Agent.log | sed '/ON connect >> connected >> registered : true/ q'
+  sudo timeout 10s tail -f "$DATA_DIR"/logs/beeta_Agent.log | sed '/ON connect >> connected >> registered : true/ q'
 }
 
 cleanup() {
@@ -278,6 +280,9 @@ LOG_FILE=installer.log
 
 BEETA_AGENT_DIR="$PWD/beeta-agent"
 
+# state, keys, certificates and logs of the agent, kept when the agent is reinstalled
+DATA_DIR=/var/lib/beeta-agent
+
 SERVICE_FILE=/lib/systemd/system/beeta-agent.service
 
 S3_BUCKET="beeta-agent-dev"
@@ -15,6 +15,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 
+	"github.com/beetaone/beeta-agent/internal/audit"
 	"github.com/beetaone/beeta-agent/internal/com"
 	"github.com/beetaone/beeta-agent/internal/config"
 	"github.com/beetaone/beeta-agent/internal/docker"
@@ -22,6 +23,7 @@ import (
 	"github.com/beetaone/beeta-agent/internal/handler"
 	"github.com/beetaone/beeta-agent/internal/manifest"
 	"github.com/beetaone/beeta-agent/internal/model"
+	"github.com/beetaone/beeta-agent/internal/registry"
 	"github.com/beetaone/beeta-agent/internal/secret"
 )
 
@@ -46,18 +48,36 @@ func main() {
 	logToStdout, localManifest, deleteNode := parseCLIoptions()
 	setupLogging(logToStdout)
 
-	err := manifest.InitKnownManifests()
+	nodePubKey, err := secret.InitNodeKeypair()
+	if err != nil {
+		log.Fatal("Initialization of node keypair failed! CAUSE --> ", err)
+	}
+
+	// the audit log is keyed with the seal key, so it is opened after the node keypair
+	err = audit.Init()
+	if err != nil {
+		log.Fatal("Initialization of audit log failed! CAUSE --> ", err)
+	}
+
+	err = manifest.InitKnownManifests()
 	if err != nil {
 		log.Fatal("Initialization of known manifests failed! CAUSE --> ", err)
 	}
 
-	nodePubKey, err := secret.InitNodeKeypair()
+	err = registry.InitCredentialStore()
 	if err != nil {
-		log.Fatal("Initialization of node keypair failed! CAUSE --> ", err)
+		log.Fatal("Initialization of registry credential store failed! CAUSE --> ", err)
 	}
 
 	docker.SetupDockerClient()
 
+	err = edgeapp.ReconcileEdgeApps()
+	if err != nil {
+		log.Fatal("Reconciliation of edge apps failed! CAUSE --> ", err)
+	}
+
+	edgeapp.RestoreEdgeApps()
+
 	if localManifest != "" {
 		err := edgeapp.ReadDeployManifestLocal(localManifest)
 		if err != nil {
@@ -95,6 +115,12 @@ func main() {
 	go monitorEdgeAppStatus()
 	go sendHeartbeat()
 	go sendEdgeAppLogs()
+	if config.Params.ImageGCInvl > 0 {
+		go collectImageGarbage()
+	}
+	if config.Params.ImageImportDir != "" {
+		go importImageArchives()
+	}
 
 	log.Info("beeta-agent started and running...")
 	// Cleanup on ending the process
@@ -103,6 +129,10 @@ func main() {
 	if err != nil {
 		log.Fatal("Disconnection of node failed! CAUSE --> ", err)
 	}
+	err = manifest.CloseKnownManifests()
+	if err != nil {
+		log.Error("Failed to close the known manifests! CAUSE --> ", err)
+	}
 }
 
 func parseCLIoptions() (bool, string, bool) {
@@ -126,6 +156,30 @@ func parseCLIoptions() (bool, string, bool) {
 
 	config.Set(opt)
 
+	err = config.SetupDataDir(map[string][]string{
+		config.StateDir: {manifest.ManifestFile, manifest.StagedManifestFile, manifest.HistoryFile, manifest.BoltFile, registry.CredentialStoreFile, audit.AnchorFile},
+		config.KeysDir:  {secret.SealKeyFile, secret.OrgKeysFile},
+	})
+	if err != nil {
+		log.Fatal("Setup of the data directory failed! CAUSE --> ", err)
+	}
+
+	if opt.VerifyAudit {
+		_, err := secret.InitNodeKeypair()
+		if err != nil {
+			fmt.Printf("Initialization of node keypair failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		count, err := audit.Verify(config.Params.AuditLogFile)
+		if err != nil {
+			fmt.Printf("Audit log %v is not intact after %v entries: %v\n", config.Params.AuditLogFile, count, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Audit log %v is intact, %v entries\n", config.Params.AuditLogFile, count)
+		os.Exit(0)
+	}
+
 	return opt.Stdout, opt.ManifestPath, opt.Delete
 }
 
@@ -170,6 +224,8 @@ func setSubscriptionHandlers() map[string]mqtt.MessageHandler {
 
 	subscriptions[com.TopicOrchestration] = handler.OrchestrationHandler
 	subscriptions[com.TopicOrgPrivateKey] = handler.OrgPrivKeyHandler
+	subscriptions[com.TopicRegistryCreds] = handler.RegistryCredentialsHandler
+	subscriptions[com.TopicNodeKeyRotate] = handler.NodeKeyRotationHandler
 	subscriptions[com.TopicNodeDelete] = handler.NodeDeleteHandler
 
 	return subscriptions
@@ -232,3 +288,23 @@ func sendEdgeAppLogs() {
 		time.Sleep(time.Second * time.Duration(config.Params.LogSendInvl))
 	}
 }
+
+func collectImageGarbage() {
+	log.Debug("Start collecting unused images...")
+
+	for {
+		time.Sleep(time.Second * time.Duration(config.Params.ImageGCInvl))
+
+		edgeapp.CollectImageGarbage()
+	}
+}
+
+func importImageArchives() {
+	log.Debug("Start watching the image import directory...")
+
+	for {
+		edgeapp.ImportImageArchives()
+
+		time.Sleep(time.Second * time.Duration(config.Params.ImageImportInvl))
+	}
+}
@@ -0,0 +1,275 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/secret"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const (
+	OutcomeSuccess  = "SUCCESS"
+	OutcomeFailure  = "FAILURE"
+	OutcomeRejected = "REJECTED" // the message did not pass the verification and was not executed
+)
+
+// AnchorFile holds the number of entries and the hash of the last entry, sealed and outside the log,
+// so that removed trailing entries are detected as well
+const AnchorFile = "auditAnchor.bin"
+const anchorSealLabel = "auditAnchor"
+const keyLabel = "auditLog"
+
+// anchor is the end of the hash chain when the last entry was written
+type anchor struct {
+	Count int    `json:"count"`
+	Hash  string `json:"hash"`
+}
+
+// Entry is a line of the audit log. Each entry carries the hash of the previous one,
+// so that a modified, removed or inserted entry breaks the chain. The hashes are HMACs keyed
+// with a key derived from the seal key, so that the chain cannot be recomputed without the node key.
+type Entry struct {
+	Time       time.Time         `json:"time"`
+	Topic      string            `json:"topic"`
+	Command    string            `json:"command"`
+	ManifestID string            `json:"manifestId,omitempty"`
+	Outcome    string            `json:"outcome"`
+	Error      string            `json:"error,omitempty"`
+	Containers []ContainerChange `json:"containers,omitempty"`
+	PrevHash   string            `json:"prevHash"`
+	Hash       string            `json:"hash"`
+}
+
+// ContainerChange is a container whose state was changed by the command, an empty state means that the container did not exist
+type ContainerChange struct {
+	Name   string `json:"name"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+var auditFile *os.File
+var auditKey []byte
+var lastHash string
+var entryCount int
+var auditLock sync.Mutex
+
+// Init opens the audit log for appending and continues its hash chain, the node keypair has to be initialized.
+// Existing entries that do not form a valid chain are reported, but do not prevent new entries.
+func Init() error {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	key, err := secret.DeriveKey(keyLabel)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	auditKey = key
+
+	count, total, last, err := verify(config.Params.AuditLogFile, auditKey)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf("Audit log %v is not intact after %v entries, it may have been tampered with! CAUSE --> %v", config.Params.AuditLogFile, count, err)
+	}
+	lastHash = last
+	entryCount = total
+
+	file, err := os.OpenFile(config.Params.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	auditFile = file
+
+	return nil
+}
+
+// Record appends the entry to the audit log, the time is set if it is missing
+func Record(entry Entry) error {
+	auditLock.Lock()
+	defer auditLock.Unlock()
+
+	if auditFile == nil {
+		return errors.New("audit log is not initialized")
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.PrevHash = lastHash
+
+	hash, err := hashEntry(entry, auditKey)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	_, err = auditFile.Write(append(line, '\n'))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	err = auditFile.Sync()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	lastHash = hash
+	entryCount++
+
+	// the entry is written first, an anchor behind the log is detected as truncation, one ahead of it is not
+	err = writeAnchor(anchor{Count: entryCount, Hash: hash})
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
+
+// Verify checks the hash chain of the audit log against its anchor and returns the number of intact entries,
+// the node keypair has to be initialized
+func Verify(path string) (int, error) {
+	key, err := secret.DeriveKey(keyLabel)
+	if err != nil {
+		return 0, traceutility.Wrap(err)
+	}
+
+	count, _, _, err := verify(path, key)
+	return count, err
+}
+
+// verify returns the number of intact entries, the number of all entries and the hash of the last entry.
+// If the chain is broken, the hash of the last entry is returned anyway, so that new entries can be appended to the log.
+func verify(path string, key []byte) (int, int, string, error) {
+	anchored, err := readAnchor()
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, "", traceutility.Wrap(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && anchored != nil {
+			return 0, 0, "", fmt.Errorf("audit log with %v entries was removed", anchored.Count)
+		}
+		return 0, 0, "", err
+	}
+	defer file.Close()
+
+	count := 0
+	total := 0
+	prevHash := ""
+	var chainErr error
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		total++
+
+		var entry Entry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			if chainErr == nil {
+				chainErr = fmt.Errorf("entry %v cannot be parsed: %w", line, err)
+			}
+			continue
+		}
+
+		hash, err := hashEntry(entry, key)
+		if err != nil {
+			return count, total, prevHash, traceutility.Wrap(err)
+		}
+
+		if chainErr == nil {
+			if entry.PrevHash != prevHash {
+				chainErr = fmt.Errorf("entry %v does not follow the previous entry", line)
+			} else if entry.Hash != hash {
+				chainErr = fmt.Errorf("entry %v was modified", line)
+			} else if anchored != nil && line == anchored.Count && entry.Hash != anchored.Hash {
+				chainErr = fmt.Errorf("entry %v is not the entry the log was anchored at", line)
+			} else {
+				count++
+			}
+		}
+		prevHash = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return count, total, prevHash, traceutility.Wrap(err)
+	}
+
+	if chainErr == nil {
+		if anchored == nil && total > 0 {
+			chainErr = errors.New("the anchor of the audit log is missing")
+		} else if anchored != nil && total < anchored.Count {
+			chainErr = fmt.Errorf("audit log was truncated, it had %v entries", anchored.Count)
+		}
+	}
+
+	return count, total, prevHash, chainErr
+}
+
+// hashEntry returns the HMAC-SHA256 of the entry without its own hash
+func hashEntry(entry Entry, key []byte) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// readAnchor returns the sealed anchor of the audit log
+func readAnchor() (*anchor, error) {
+	sealed, err := os.ReadFile(config.StatePath(AnchorFile))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := secret.Unseal(sealed, anchorSealLabel)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	var a anchor
+	err = json.Unmarshal(data, &a)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	return &a, nil
+}
+
+// writeAnchor seals the anchor of the audit log and replaces the previous one
+func writeAnchor(a anchor) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	sealed, err := secret.Seal(data, anchorSealLabel)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	anchorFile := config.StatePath(AnchorFile)
+	tmpFile := anchorFile + ".tmp"
+	err = os.WriteFile(tmpFile, sealed, 0600)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return os.Rename(tmpFile, anchorFile)
+}
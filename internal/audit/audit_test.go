@@ -0,0 +1,84 @@
+package audit_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/audit"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/secret"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+
+	// the node key, the seal key and the anchor of the log are kept in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secret.InitNodeKeypair(); err != nil {
+		t.Fatal(err)
+	}
+	config.Params.AuditLogFile = filepath.Join(t.TempDir(), "audit.jsonl")
+
+	if err := audit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(audit.Record(audit.Entry{Topic: "node/orchestration", Command: "DEPLOY", ManifestID: "app", Outcome: audit.OutcomeSuccess,
+		Containers: []audit.ContainerChange{{Name: "app-module", After: "running"}}}))
+	assert.Nil(audit.Record(audit.Entry{Topic: "node/orchestration", Command: "STOP", ManifestID: "app", Outcome: audit.OutcomeSuccess}))
+
+	// the chain continues after a restart
+	if err := audit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(audit.Record(audit.Entry{Topic: "node/orchestration", Outcome: audit.OutcomeRejected, Error: "not signed"}))
+
+	count, err := audit.Verify(config.Params.AuditLogFile)
+	assert.Nil(err)
+	assert.Equal(3, count)
+
+	content, err := os.ReadFile(config.Params.AuditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated log
+	lines := strings.SplitAfter(string(content), "\n")
+	truncated := lines[0] + lines[1]
+	if err := os.WriteFile(config.Params.AuditLogFile, []byte(truncated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	count, err = audit.Verify(config.Params.AuditLogFile)
+	assert.NotNil(err)
+	assert.Equal(2, count)
+
+	// modified entry
+	tampered := strings.Replace(string(content), `"command":"STOP"`, `"command":"RESUME"`, 1)
+	if err := os.WriteFile(config.Params.AuditLogFile, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	count, err = audit.Verify(config.Params.AuditLogFile)
+	assert.NotNil(err)
+	assert.Equal(1, count)
+
+	// removed entry
+	removed := lines[0] + lines[2]
+	if err := os.WriteFile(config.Params.AuditLogFile, []byte(removed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	count, err = audit.Verify(config.Params.AuditLogFile)
+	assert.NotNil(err)
+	assert.Equal(1, count)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"time"
@@ -21,7 +22,10 @@ const (
 	topicAgentLogs     = "agentlogs"
 	topicAppLogs       = "applogs"
 	topicNodePublicKey = "nodePublicKey"
+	topicPullProgress  = "pullprogress"
 	TopicOrgPrivateKey = "orgKey"
+	TopicRegistryCreds = "registryCredentials"
+	TopicNodeKeyRotate = "rotateNodeKey"
 	TopicNodeDelete    = "delete"
 )
 
@@ -45,6 +49,12 @@ func SendEdgeAppLogs(msg []EdgeAppLogMsg) error {
 	return nil
 }
 
+func SendPullProgress(msg PullProgressMsg) error {
+	pullProgressTopic := topicPullProgress + "/" + config.Params.NodeId
+	log.Debugln("Sending pull progress >>", "Topic:", pullProgressTopic, ">> Body:", msg)
+	return publishMessage(pullProgressTopic, msg, false, 0)
+}
+
 func SendNodePublicKey(nodePublicKey []byte) error {
 	topic := topicNodePublicKey + "/" + config.Params.NodeId
 	msg := nodePublicKeyMsg{
@@ -172,6 +182,11 @@ func newTLSConfig() (*tls.Config, error) {
 }
 
 func publishMessage(topic string, message interface{}, retained bool, qos byte) error {
+	// messages can be published before the node is connected, e.g. while edge apps are restored on startup
+	if client == nil {
+		return errors.New("node is not connected")
+	}
+
 	payload, err := json.Marshal(message)
 	if err != nil {
 		return traceutility.Wrap(err)
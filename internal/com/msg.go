@@ -25,6 +25,26 @@ type EdgeAppMsg struct {
 	ManifestID string         `json:"manifestID"`
 	Status     string         `json:"status"`
 	Containers []ContainerMsg `json:"containers"`
+	History    []time.Time    `json:"history,omitempty"` // updatedAt of the deployed versions kept to roll back to
+	// the secret values could not be stored, the edge app is not restored or rolled back until the manager deploys it again
+	SecretsLost bool `json:"secretsLost,omitempty"`
+	// the manifest was rebuilt from the containers after the known manifests were lost, the manager has to deploy the edge app again
+	Rebuilt bool `json:"rebuilt,omitempty"`
+}
+
+type PullProgressMsg struct {
+	ManifestID string `json:"manifestID"`
+	Image      string `json:"image"`
+	Attempt    int    `json:"attempt"`
+	Downloaded int64  `json:"downloaded"` // bytes
+	Total      int64  `json:"total"`      // bytes
+	ETA        int64  `json:"eta"`        // seconds, -1 if unknown
+}
+
+type StagedEdgeAppMsg struct {
+	ManifestID string    `json:"manifestID"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Status     string    `json:"status"`
 }
 
 type agentLogMsg struct {
@@ -34,11 +54,39 @@ type agentLogMsg struct {
 }
 
 type StatusMsg struct {
-	Status           string          `json:"status"`
-	EdgeApplications []EdgeAppMsg    `json:"edgeApplications"`
-	DeviceParams     DeviceParamsMsg `json:"deviceParams"`
-	AgentVersion     string          `json:"agentVersion"`
-	OrgKeyHash       string          `json:"orgKeyHash"`
+	Status              string                  `json:"status"`
+	EdgeApplications    []EdgeAppMsg            `json:"edgeApplications"`
+	StagedEdgeApps      []StagedEdgeAppMsg      `json:"stagedEdgeApplications"`
+	DeviceParams        DeviceParamsMsg         `json:"deviceParams"`
+	AgentVersion        string                  `json:"agentVersion"`
+	OrgKeyHash          string                  `json:"orgKeyHash"`
+	OrgKeyHashes        []string                `json:"orgKeyHashes"`
+	NodeKeyRotation     bool                    `json:"nodeKeyRotationPending"`
+	ImageGC             ImageGCMsg              `json:"imageGC"`
+	RegistryCredentials []RegistryCredentialMsg `json:"registryCredentials"`
+	OrphanedEdgeApps    []OrphanedEdgeAppMsg    `json:"orphanedEdgeApplications"`
+}
+
+// OrphanedEdgeAppMsg is an edge app found on the node by its labels, but not known to the agent
+type OrphanedEdgeAppMsg struct {
+	ManifestID string   `json:"manifestID"`
+	Containers []string `json:"containers"`
+	Networks   []string `json:"networks"`
+}
+
+type RegistryCredentialMsg struct {
+	Name      string     `json:"name"`
+	Registry  string     `json:"registry"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Expired   bool       `json:"expired"`
+}
+
+type ImageGCMsg struct {
+	LastRun        time.Time `json:"lastRun"`
+	DiskUsage      float64   `json:"diskUsage"` // %
+	RemovedImages  []string  `json:"removedImages"`
+	ReclaimedSpace int64     `json:"reclaimedSpace"` // bytes
+	Error          string    `json:"error,omitempty"`
 }
 
 type DeviceParamsMsg struct {
@@ -46,6 +94,11 @@ type DeviceParamsMsg struct {
 	SystemLoad   float64 `json:"systemLoad"`
 	StorageFree  float64 `json:"storageFree"`
 	RamFree      float64 `json:"ramFree"`
+	// capacity of the node available to edge apps, which admission control checks the edge apps against
+	CPUCapacity          float64 `json:"cpuCapacity"`
+	RamCapacity          int64   `json:"ramCapacity"`          // bytes
+	StorageCapacity      int64   `json:"storageCapacity"`      // bytes
+	StorageFreeAvailable int64   `json:"storageFreeAvailable"` // bytes
 }
 
 type nodePublicKeyMsg struct {
@@ -13,6 +13,7 @@ import (
 )
 
 type ParamStruct struct {
+	DataDir      string // relative file names are resolved in its layout, empty keeps them relative to the working directory
 	Broker       string
 	NodeId       string
 	NodeName     string
@@ -25,9 +26,81 @@ type ParamStruct struct {
 	LogAge       int
 	LogBackup    int
 	LogCompress  bool
+	AuditLogFile string // hash-chained log of the received manager messages, kept apart from the rotated log
 	MqttLogs     bool
 	Heartbeat    int
 	LogSendInvl  int
+	PullRetries  int
+	PullBackoff  int
+	PullParallel int
+	PullMaxRate  int // KB/s, 0 means no limit
+	ImageGCInvl  int // sec, 0 disables the image garbage collection
+	ImageGCHigh  float64
+	ImageGCLow   float64
+	ImageGCKeep  int
+
+	// offline import of images saved with docker save, an empty directory disables the watched directory
+	ImageImportDir  string // directory watched for image archives, each with a <archive>.sha256 checksum file
+	ImageImportInvl int    // sec
+
+	// caps applied to every edge app module so that a single module cannot starve the node, 0 means no cap
+	ModuleMaxCPUs   float64
+	ModuleMaxMemory int64 // MB
+	ModuleMaxPids   int64
+
+	// capacity of the node used for admission control of new edge apps, 0 means the capacity reported by the host
+	NodeCPUCapacity    float64
+	NodeMemoryCapacity int64 // MB
+	NodeDiskCapacity   int64 // MB
+
+	// resources reserved by admission control for modules that do not request them
+	ModuleDefaultCPUs float64
+	ModuleDefaultMem  int64 // MB
+
+	// host resources edge apps are allowed to access, an empty allow-list does not restrict the access
+	AllowedHostPaths   []string // host paths (and everything below them) that may be bind mounted
+	AllowedDevices     []string // glob patterns of host devices that may be mapped, e.g. /dev/ttyUSB*
+	ReadOnlyHostMounts bool     // reject bind mounts that are not read-only
+	DevicePermissions  string   // max cgroup permissions of mapped devices: r, rw or rwm
+
+	// security options applied to all edge app containers on top of the ones requested by the modules
+	AllowPrivileged     bool     // allow privileged containers and unconfined seccomp/AppArmor profiles
+	DropAllCapabilities bool     // drop all capabilities, modules have to add the ones they need explicitly
+	AllowedCapabilities []string // capabilities modules may add, empty allows all
+	NoNewPrivileges     bool     // prevent processes in containers from gaining new privileges
+	SeccompProfilesDir  string   // directory with the seccomp profiles (<name>.json) modules can refer to
+	SecretsDir          string   // directory on a tmpfs the secret files of the modules are written to
+
+	// where the manifests are kept: file (a JSON file each for the known and staged manifests and the history)
+	// or bolt (an embedded key-value database updated per record)
+	StateBackend string
+
+	// handling of edge apps found on startup by their labels, but not known to the agent: report, adopt or cleanup
+	OrphanPolicy string
+
+	// number of deployed versions kept per edge app to roll back to, 0 disables the history
+	HistoryVersions int
+
+	// verification of edge app images
+	RequireSignedImages bool     // refuse images that are not pinned by a digest and signed
+	ImageVerifyKeys     []string // paths to the PEM encoded public keys image signatures are verified with
+
+	// verification of messages signed by the manager
+	RequireSignedMessages bool     // reject manager messages that are not signed, implied by ManagerVerifyKeys
+	ManagerVerifyKeys     []string // paths to the PEM encoded public keys of the manager, unsigned messages are rejected once set
+	MessageMaxAge         int      // sec, max difference between the timestamp of a signed message and the node time
+
+	// node identity key and organization keys
+	NodeKeyAlgorithm  string // algorithm of newly generated node keys: rsa, ecdsa or ed25519
+	NodeKeyPath       string
+	OrgKeyGracePeriod int // sec, time a replaced org key is kept to decrypt manifests that were encrypted with it
+
+	// docker credential helpers per registry host, e.g. {"123456789.dkr.ecr.eu-west-1.amazonaws.com": "ecr-login"}
+	RegistryCredentialHelpers map[string]string
+
+	// registry mirrors (pull-through caches) per registry host, tried in order before the registry itself,
+	// e.g. {"docker.io": ["mirror.local:5000"]}
+	RegistryMirrors map[string][]string
 }
 
 // default values
@@ -41,9 +114,34 @@ var Params = ParamStruct{
 	LogAge:       1,
 	LogBackup:    5,
 	LogCompress:  false,
+	AuditLogFile: "beeta_Agent_audit.jsonl",
 	MqttLogs:     false,
 	Heartbeat:    10,
 	LogSendInvl:  60,
+	PullRetries:  3,
+	PullBackoff:  5,
+	PullParallel: 1,
+	PullMaxRate:  0,
+	ImageGCInvl:  600,
+	ImageGCHigh:  85,
+	ImageGCLow:   70,
+	ImageGCKeep:  1,
+
+	ImageImportInvl: 30,
+
+	ModuleDefaultCPUs: 0.1,
+	ModuleDefaultMem:  64,
+
+	DevicePermissions:  "rw",
+	SeccompProfilesDir: "/etc/beeta-agent/seccomp",
+	SecretsDir:         "/run/beeta-agent/secrets",
+	OrphanPolicy:       "report",
+	StateBackend:       "file",
+	HistoryVersions:    3,
+	MessageMaxAge:      300,
+	NodeKeyAlgorithm:   "rsa",
+	NodeKeyPath:        "nodePrivateKey.pem",
+	OrgKeyGracePeriod:  7 * 24 * 60 * 60,
 }
 
 func Set(opt model.Params) {
@@ -72,6 +170,10 @@ func readNodeConfigFromFile(configPath string) {
 }
 
 func applyCLIparams(opt model.Params) {
+	if opt.DataDir != "" {
+		Params.DataDir = opt.DataDir
+	}
+
 	if opt.Broker != "" {
 		Params.Broker = opt.Broker
 	}
@@ -104,6 +206,10 @@ func applyCLIparams(opt model.Params) {
 		Params.LogFileName = opt.LogFileName
 	}
 
+	if opt.AuditLogFile != "" {
+		Params.AuditLogFile = opt.AuditLogFile
+	}
+
 	if opt.LogSize > 0 {
 		Params.LogSize = opt.LogSize
 	}
@@ -131,6 +237,87 @@ func applyCLIparams(opt model.Params) {
 	if opt.LogSendInvl > 0 {
 		Params.LogSendInvl = opt.LogSendInvl
 	}
+
+	// set only if the flag was given, so that 0 disables the retries
+	if opt.PullRetries != nil {
+		Params.PullRetries = *opt.PullRetries
+	}
+
+	if opt.PullBackoff > 0 {
+		Params.PullBackoff = opt.PullBackoff
+	}
+
+	if opt.PullParallel > 0 {
+		Params.PullParallel = opt.PullParallel
+	}
+
+	if opt.PullMaxRate > 0 {
+		Params.PullMaxRate = opt.PullMaxRate
+	}
+
+	if opt.ImageGCInvl > 0 {
+		Params.ImageGCInvl = opt.ImageGCInvl
+	}
+
+	if opt.ImageGCHigh > 0 {
+		Params.ImageGCHigh = opt.ImageGCHigh
+	}
+
+	if opt.ImageGCLow > 0 {
+		Params.ImageGCLow = opt.ImageGCLow
+	}
+
+	if opt.ImageGCKeep > 0 {
+		Params.ImageGCKeep = opt.ImageGCKeep
+	}
+
+	if opt.NodeKeyAlgorithm != "" {
+		Params.NodeKeyAlgorithm = opt.NodeKeyAlgorithm
+	}
+
+	if opt.NodeKeyPath != "" {
+		Params.NodeKeyPath = opt.NodeKeyPath
+	}
+
+	if opt.ImageImportDir != "" {
+		Params.ImageImportDir = opt.ImageImportDir
+	}
+
+	if opt.ImageImportInvl > 0 {
+		Params.ImageImportInvl = opt.ImageImportInvl
+	}
+
+	if opt.ModuleMaxCPUs > 0 {
+		Params.ModuleMaxCPUs = opt.ModuleMaxCPUs
+	}
+
+	if opt.ModuleMaxMemory > 0 {
+		Params.ModuleMaxMemory = opt.ModuleMaxMemory
+	}
+
+	if opt.ModuleMaxPids > 0 {
+		Params.ModuleMaxPids = opt.ModuleMaxPids
+	}
+
+	if opt.NodeCPUCapacity > 0 {
+		Params.NodeCPUCapacity = opt.NodeCPUCapacity
+	}
+
+	if opt.NodeMemoryCapacity > 0 {
+		Params.NodeMemoryCapacity = opt.NodeMemoryCapacity
+	}
+
+	if opt.NodeDiskCapacity > 0 {
+		Params.NodeDiskCapacity = opt.NodeDiskCapacity
+	}
+
+	if opt.ModuleDefaultCPUs > 0 {
+		Params.ModuleDefaultCPUs = opt.ModuleDefaultCPUs
+	}
+
+	if opt.ModuleDefaultMem > 0 {
+		Params.ModuleDefaultMem = opt.ModuleDefaultMem
+	}
 }
 
 func validateConfig() {
@@ -144,6 +331,78 @@ func validateConfig() {
 	}
 	validateBrokerUrl(brokerUrl)
 
+	if Params.PullRetries < 0 {
+		log.Fatal("The number of image pull retries must not be negative")
+	}
+
+	if Params.PullParallel < 1 {
+		log.Fatal("At least one image pull must be allowed at a time")
+	}
+
+	if Params.ImageGCLow > Params.ImageGCHigh || Params.ImageGCHigh > 100 || Params.ImageGCKeep < 0 {
+		log.Fatal("Image garbage collection requires 0 <= low watermark <= high watermark <= 100 and a non-negative number of versions to keep")
+	}
+
+	if Params.ImageImportDir != "" && Params.ImageImportInvl < 1 {
+		log.Fatal("The image import directory must be checked at least every ImageImportInvl seconds, which must be positive")
+	}
+
+	if Params.ModuleMaxCPUs < 0 || Params.ModuleMaxMemory < 0 || Params.ModuleMaxPids < 0 {
+		log.Fatal("Module resource caps must not be negative")
+	}
+
+	if Params.NodeCPUCapacity < 0 || Params.NodeMemoryCapacity < 0 || Params.NodeDiskCapacity < 0 {
+		log.Fatal("Node capacity must not be negative")
+	}
+
+	if Params.ModuleDefaultCPUs < 0 || Params.ModuleDefaultMem < 0 {
+		log.Fatal("Default module resource requests must not be negative")
+	}
+
+	if Params.NodeKeyAlgorithm != "rsa" && Params.NodeKeyAlgorithm != "ecdsa" && Params.NodeKeyAlgorithm != "ed25519" {
+		log.Fatalf("Invalid node key algorithm %v, allowed are rsa, ecdsa and ed25519", Params.NodeKeyAlgorithm)
+	}
+
+	if Params.OrgKeyGracePeriod < 0 {
+		log.Fatal("The grace period of replaced org keys must not be negative")
+	}
+
+	if Params.RequireSignedImages && len(Params.ImageVerifyKeys) == 0 {
+		log.Fatal("Signed images are required, but no keys to verify the signatures are configured")
+	}
+
+	if Params.RequireSignedMessages && len(Params.ManagerVerifyKeys) == 0 {
+		log.Fatal("Signed manager messages are required, but no manager keys to verify the signatures are configured")
+	}
+
+	if Params.MessageMaxAge < 1 {
+		log.Fatal("The max age of signed manager messages must be positive")
+	}
+
+	for registry, mirrors := range Params.RegistryMirrors {
+		for _, mirror := range mirrors {
+			if strings.Contains(mirror, "://") {
+				log.Fatalf("Invalid mirror %v of registry %v, mirrors are given as host[:port][/path] without a scheme", mirror, registry)
+			}
+		}
+	}
+
+	if Params.StateBackend != "file" && Params.StateBackend != "bolt" {
+		log.Fatalf("Invalid state backend %v, allowed are file and bolt", Params.StateBackend)
+	}
+
+	if Params.HistoryVersions < 0 {
+		log.Fatalf("Invalid number of history versions %v, it must not be negative", Params.HistoryVersions)
+	}
+
+	if Params.OrphanPolicy != "report" && Params.OrphanPolicy != "adopt" && Params.OrphanPolicy != "cleanup" {
+		log.Fatalf("Invalid orphan policy %v, allowed are report, adopt and cleanup", Params.OrphanPolicy)
+	}
+
+	if Params.DevicePermissions != "r" && Params.DevicePermissions != "rw" && Params.DevicePermissions != "rwm" {
+		log.Fatalf("Invalid device permissions %v, allowed are r, rw and rwm", Params.DevicePermissions)
+	}
+
 	if Params.NoTLS {
 		log.Info("TLS disabled!")
 	} else {
@@ -0,0 +1,34 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/model"
+)
+
+func TestSet_PullRetries(t *testing.T) {
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+
+	retries := func(n int) *int { return &n }
+
+	tests := []struct {
+		name     string
+		retries  *int
+		expected int
+	}{
+		{"flag not set", nil, 3},
+		{"retries disabled", retries(0), 0},
+		{"retries set", retries(5), 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Params.PullRetries = 3
+			config.Set(model.Params{Broker: "tls://broker.example.com:8883", PullRetries: test.retries})
+			assert.Equal(t, test.expected, config.Params.PullRetries)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// layout of the data directory
+const (
+	StateDir = "state" // known manifests, registry credentials
+	KeysDir  = "keys"  // node key, seal key, org keys
+	CertsDir = "certs" // root certificate of the broker
+	LogsDir  = "logs"  // application and audit log
+	CacheDir = "cache" // data that can be recreated, e.g. downloads
+)
+
+// StatePath returns the path of a state file in the data directory
+func StatePath(name string) string {
+	return dataPath(StateDir, name)
+}
+
+// KeyPath returns the path of a key file in the data directory
+func KeyPath(name string) string {
+	return dataPath(KeysDir, name)
+}
+
+// dataPath resolves a relative file name in the subdirectory of the data directory.
+// Without a data directory the files are relative to the working directory, as before the data directory was introduced.
+func dataPath(dir string, name string) string {
+	if Params.DataDir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(Params.DataDir, dir, name)
+}
+
+// SetupDataDir creates the layout of the data directory, moves the files of an agent that used
+// the working directory into it and checks the permissions. The files are the relative file names
+// the packages store in each subdirectory, the paths of the config params are resolved in addition.
+func SetupDataDir(files map[string][]string) error {
+	if Params.DataDir == "" {
+		return nil
+	}
+
+	dataDir, err := filepath.Abs(Params.DataDir)
+	if err != nil {
+		return err
+	}
+	Params.DataDir = dataDir
+
+	for _, dir := range []string{"", StateDir, CertsDir, LogsDir, CacheDir} {
+		err := os.MkdirAll(filepath.Join(dataDir, dir), 0750)
+		if err != nil {
+			return err
+		}
+	}
+	// the keys never leave the agent
+	err = os.MkdirAll(filepath.Join(dataDir, KeysDir), 0700)
+	if err != nil {
+		return err
+	}
+
+	// the node key may have a pending replacement next to it
+	files[KeysDir] = append(files[KeysDir], Params.NodeKeyPath, Params.NodeKeyPath+".new")
+	files[CertsDir] = append(files[CertsDir], Params.RootCertPath)
+	files[LogsDir] = append(files[LogsDir], Params.LogFileName, Params.AuditLogFile)
+	files[LogsDir] = append(files[LogsDir], rotatedLogFiles(Params.LogFileName)...)
+
+	for dir, names := range files {
+		for _, name := range names {
+			err := migrateFile(dir, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	Params.NodeKeyPath = dataPath(KeysDir, Params.NodeKeyPath)
+	Params.RootCertPath = dataPath(CertsDir, Params.RootCertPath)
+	Params.LogFileName = dataPath(LogsDir, Params.LogFileName)
+	Params.AuditLogFile = dataPath(LogsDir, Params.AuditLogFile)
+
+	return checkDataDirPermissions()
+}
+
+// migrateFile moves a file from the working directory to the data directory, unless the data directory has it already
+func migrateFile(dir string, name string) error {
+	target := dataPath(dir, name)
+	if target == name {
+		return nil
+	}
+
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(target); err == nil {
+		log.Warnf("Not moving %v to the data directory, %v exists already", name, target)
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0750)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(name, target)
+	if err != nil {
+		return err
+	}
+	if dir == KeysDir {
+		err = os.Chmod(target, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Moved %v to %v", name, target)
+	return nil
+}
+
+// rotatedLogFiles returns the backups of the log file in the working directory, named <name>-<time><ext>[.gz] by lumberjack
+func rotatedLogFiles(logFileName string) []string {
+	if filepath.IsAbs(logFileName) {
+		return nil
+	}
+
+	ext := filepath.Ext(logFileName)
+	backups, _ := filepath.Glob(strings.TrimSuffix(logFileName, ext) + "-*" + ext + "*")
+	return backups
+}
+
+// checkDataDirPermissions refuses a data directory that others can write to and keys that others can read
+func checkDataDirPermissions() error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	for _, dir := range []string{"", StateDir, CertsDir, LogsDir, CacheDir, KeysDir} {
+		path := filepath.Join(Params.DataDir, dir)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0022 != 0 {
+			return fmt.Errorf("%v is writable by other users (mode %v), restrict it with chmod go-w", path, info.Mode().Perm())
+		}
+	}
+
+	keys, err := os.ReadDir(filepath.Join(Params.DataDir, KeysDir))
+	if err != nil {
+		return err
+	}
+	paths := []string{filepath.Join(Params.DataDir, KeysDir)}
+	for _, key := range keys {
+		paths = append(paths, filepath.Join(Params.DataDir, KeysDir, key.Name()))
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			return fmt.Errorf("%v is accessible by other users (mode %v), restrict it with chmod go-rwx", path, info.Mode().Perm())
+		}
+	}
+
+	return nil
+}
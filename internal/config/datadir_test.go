@@ -0,0 +1,71 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+)
+
+func TestSetupDataDir(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	workDir := t.TempDir()
+	if err := os.Chdir(workDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// files of an agent that used the working directory
+	for _, name := range []string{"known_manifests.jsonl", "auditAnchor.bin", "nodePrivateKey.pem", "ca.crt", "beeta_Agent.log"} {
+		if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDir := filepath.Join(workDir, "data")
+	config.Params.DataDir = dataDir
+	config.Params.NodeKeyPath = "nodePrivateKey.pem"
+	config.Params.RootCertPath = "ca.crt"
+	config.Params.LogFileName = "beeta_Agent.log"
+	config.Params.AuditLogFile = "/var/log/audit.jsonl"
+
+	err = config.SetupDataDir(map[string][]string{config.StateDir: {"known_manifests.jsonl", "staged_manifests.jsonl", "auditAnchor.bin"}})
+	assert.Nil(err)
+
+	assert.Equal(filepath.Join(dataDir, "keys", "nodePrivateKey.pem"), config.Params.NodeKeyPath)
+	assert.Equal(filepath.Join(dataDir, "certs", "ca.crt"), config.Params.RootCertPath)
+	assert.Equal(filepath.Join(dataDir, "logs", "beeta_Agent.log"), config.Params.LogFileName)
+	assert.Equal("/var/log/audit.jsonl", config.Params.AuditLogFile)
+	assert.Equal(filepath.Join(dataDir, "state", "known_manifests.jsonl"), config.StatePath("known_manifests.jsonl"))
+
+	for _, path := range []string{config.Params.NodeKeyPath, config.Params.RootCertPath, config.Params.LogFileName, config.StatePath("known_manifests.jsonl"), config.StatePath("auditAnchor.bin")} {
+		content, err := os.ReadFile(path)
+		assert.Nil(err)
+		assert.Equal(filepath.Base(path), string(content))
+	}
+	info, err := os.Stat(config.Params.NodeKeyPath)
+	if assert.Nil(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+	for _, name := range []string{"known_manifests.jsonl", "auditAnchor.bin"} {
+		_, err = os.Stat(name)
+		assert.True(os.IsNotExist(err))
+	}
+
+	// keys readable by other users are refused
+	config.Params.NodeKeyPath = "nodePrivateKey.pem"
+	if err := os.Chmod(filepath.Join(dataDir, "keys", "nodePrivateKey.pem"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(config.SetupDataDir(map[string][]string{}))
+}
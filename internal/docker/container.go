@@ -33,8 +33,13 @@ func SetupDockerClient() {
 func createContainer(containerConfig manifest.ContainerConfig) (string, error) {
 	log.Debugln("Creating container", containerConfig.ContainerName, "from", containerConfig.ImageNameFull)
 
+	imageName, err := resolveImageName(containerConfig.ImageNameFull)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
 	config := &container.Config{
-		Image:        containerConfig.ImageNameFull,
+		Image:        imageName,
 		AttachStdin:  false,
 		AttachStdout: true,
 		AttachStderr: true,
@@ -42,19 +47,23 @@ func createContainer(containerConfig manifest.ContainerConfig) (string, error) {
 		Tty:          false,
 		ExposedPorts: containerConfig.ExposedPorts,
 		Labels:       containerConfig.Labels,
+		User:         containerConfig.User,
 	}
 
 	hostConfig := &container.HostConfig{
 		LogConfig: container.LogConfig{
 			Type: "local", // From https://docs.docker.com/config/containers/logging/local/: By default, the local driver preserves 100MB of log messages per container and uses automatic compression to reduce the size on disk. The 100MB default value is based on a 20M default size for each file and a default count of 5 for the number of such files (to account for log rotation).
 		},
-		PortBindings: containerConfig.PortBinding,
-		RestartPolicy: container.RestartPolicy{
-			Name:              "on-failure",
-			MaximumRetryCount: 100,
-		},
-		Mounts:    containerConfig.MountConfigs,
-		Resources: containerConfig.Resources,
+		PortBindings:   containerConfig.PortBinding,
+		RestartPolicy:  containerConfig.RestartPolicy,
+		Mounts:         containerConfig.MountConfigs,
+		Resources:      containerConfig.Resources,
+		OomScoreAdj:    containerConfig.OomScoreAdj,
+		Privileged:     containerConfig.Privileged,
+		CapAdd:         containerConfig.CapAdd,
+		CapDrop:        containerConfig.CapDrop,
+		SecurityOpt:    containerConfig.SecurityOpt,
+		ReadonlyRootfs: containerConfig.ReadonlyRoot,
 	}
 
 	networkConfig := &network.NetworkingConfig{
@@ -111,6 +120,8 @@ func StopAndRemoveContainer(containerID string) error {
 		log.Errorf("Unable to stop container %s: %s. Will try to force remove...", containerID, err)
 	}
 
+	// only the anonymous volumes of the container are removed,
+	// the named volumes owned by the edge app are kept until the edge app is removed (see RemoveEdgeAppVolumes)
 	removeOptions := types.ContainerRemoveOptions{
 		RemoveVolumes: true,
 		Force:         true,
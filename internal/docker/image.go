@@ -3,18 +3,155 @@ package docker
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
-	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
-
 	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
 )
 
-func PullImage(authConfig types.AuthConfig, imageName string) error {
+// PullProgress is the progress of an image pull summed up over all layers of the image
+type PullProgress struct {
+	Current int64
+	Total   int64
+}
+
+// PullImage pulls the image and reports the download progress to the optional progress callback.
+// The image is pulled through the mirrors configured for its registry first, falling back to the registry itself.
+// The download from the registry is limited by the optional limiter, the mirrors are expected in the local network.
+func PullImage(authConfig types.AuthConfig, imageName string, limiter *BandwidthLimiter, progress func(PullProgress)) error {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	for _, mirror := range config.Params.RegistryMirrors[reference.Domain(named)] {
+		err := pullImageFromMirror(named, mirror, progress)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("Pulling image %v from mirror %v failed, falling back to the next mirror or the registry. CAUSE --> %v", imageName, mirror, err)
+	}
+
+	if limiter != nil {
+		return pullImageThroughProxy(authConfig, named, limiter, progress)
+	}
+
+	return pullImage(authConfig, imageName, progress)
+}
+
+// pullImageFromMirror pulls the image from the mirror and makes it available under its original name without contacting
+// the origin registry. The credentials of the origin registry are not sent to the mirror, the mirror has to hold its own.
+func pullImageFromMirror(named reference.Named, mirror string, progress func(PullProgress)) error {
+	return pullImageFromHost(types.AuthConfig{}, named, strings.TrimSuffix(mirror, "/"), progress)
+}
+
+// pullImageFromHost pulls the image from another registry host than its own and tags it with its original name.
+// A digest cannot be tagged, so an image pinned by a digest is found by the repository digest of the other host instead,
+// the digest identifies the image regardless of the repository it was pulled from.
+func pullImageFromHost(authConfig types.AuthConfig, named reference.Named, host string, progress func(PullProgress)) error {
+	hostName := host + "/" + reference.Path(named)
+
+	if digested, ok := named.(reference.Digested); ok {
+		hostRef := hostName + "@" + digested.Digest().String()
+		err := pullImage(authConfig, hostRef, progress)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+
+		image, _, err := dockerClient.ImageInspectWithRaw(ctx, hostRef)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if !hasRepoDigest(image.RepoDigests, digested.Digest().String()) {
+			return fmt.Errorf("image %v pulled from %v does not have the digest %v", named, host, digested.Digest())
+		}
+
+		// the tag is only informational if the image is pinned by a digest
+		if tagged, ok := named.(reference.Tagged); ok {
+			err = dockerClient.ImageTag(ctx, image.ID, reference.TrimNamed(named).String()+":"+tagged.Tag())
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+		}
+		return nil
+	}
+
+	tagged := reference.TagNameOnly(named).(reference.Tagged)
+	hostRef := hostName + ":" + tagged.Tag()
+
+	err := pullImage(authConfig, hostRef, progress)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = dockerClient.ImageTag(ctx, hostRef, reference.TagNameOnly(named).String())
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	// only removes the tag of the other host, the image itself is kept under its original name
+	_, err = dockerClient.ImageRemove(ctx, hostRef, types.ImageRemoveOptions{})
+	if err != nil {
+		log.Warnf("Removing tag %v failed! CAUSE --> %v", hostRef, err)
+	}
+
+	return nil
+}
+
+// resolveImageName returns the name the docker daemon knows the local image by. An image pinned by a digest
+// that was pulled from another host, e.g. a mirror, is only known by the repository digest of that host.
+func resolveImageName(imageName string) (string, error) {
+	_, _, err := dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err == nil {
+		return imageName, nil
+	}
+	if !client.IsErrNotFound(err) {
+		return "", traceutility.Wrap(err)
+	}
+
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return imageName, nil
+	}
+	digested, ok := named.(reference.Digested)
+	if !ok {
+		return imageName, nil
+	}
+
+	images, err := dockerClient.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+	for _, image := range images {
+		for _, repoDigest := range image.RepoDigests {
+			if strings.HasSuffix(repoDigest, "@"+digested.Digest().String()) {
+				return repoDigest, nil
+			}
+		}
+	}
+
+	return imageName, nil
+}
+
+func hasRepoDigest(repoDigests []string, digest string) bool {
+	for _, repoDigest := range repoDigests {
+		if strings.HasSuffix(repoDigest, "@"+digest) {
+			return true
+		}
+	}
+	return false
+}
+
+func pullImage(authConfig types.AuthConfig, imageName string, progress func(PullProgress)) error {
 	encodedJSON, err := json.Marshal(authConfig)
 	if err != nil {
 		return traceutility.Wrap(err)
@@ -30,8 +167,10 @@ func PullImage(authConfig types.AuthConfig, imageName string) error {
 
 	d := json.NewDecoder(events)
 
+	layers := make(map[string]jsonmessage.JSONProgress)
 	var event *jsonmessage.JSONMessage
 	for {
+		event = nil
 		if err := d.Decode(&event); err != nil {
 			if err == io.EOF {
 				break
@@ -39,6 +178,14 @@ func PullImage(authConfig types.AuthConfig, imageName string) error {
 			return traceutility.Wrap(err)
 		}
 		log.Debugln(event.Status, event.Progress)
+
+		if event.Error != nil {
+			return traceutility.Wrap(event.Error)
+		}
+
+		if progress != nil && event.ID != "" && updateLayerProgress(layers, event) {
+			progress(sumLayerProgress(layers))
+		}
 	}
 
 	if event != nil {
@@ -48,10 +195,48 @@ func PullImage(authConfig types.AuthConfig, imageName string) error {
 	return nil
 }
 
+// updateLayerProgress tracks the download progress of the layer the event is about and reports if it changed
+func updateLayerProgress(layers map[string]jsonmessage.JSONProgress, event *jsonmessage.JSONMessage) bool {
+	layer := layers[event.ID]
+
+	switch event.Status {
+	case "Downloading":
+		if event.Progress == nil {
+			return false
+		}
+		layer.Current = event.Progress.Current
+		layer.Total = event.Progress.Total
+	case "Download complete", "Pull complete", "Already exists":
+		if layer.Total == 0 || layer.Current == layer.Total {
+			return false
+		}
+		layer.Current = layer.Total
+	default:
+		return false
+	}
+
+	layers[event.ID] = layer
+	return true
+}
+
+func sumLayerProgress(layers map[string]jsonmessage.JSONProgress) PullProgress {
+	var sum PullProgress
+	for _, layer := range layers {
+		sum.Current += layer.Current
+		sum.Total += layer.Total
+	}
+	return sum
+}
+
 // Check if the image exists in the local context
 // Return an error only if something went wrong, if the image is not found the error is nil
 func ImageExists(imageName string) (bool, error) {
-	_, _, err := dockerClient.ImageInspectWithRaw(ctx, imageName)
+	imageName, err := resolveImageName(imageName)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	_, _, err = dockerClient.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		if client.IsErrNotFound(err) {
 			return false, nil
@@ -63,6 +248,24 @@ func ImageExists(imageName string) (bool, error) {
 	return true, nil
 }
 
+// GetImageID returns the ID of the local image, or an empty string if the image does not exist
+func GetImageID(imageName string) (string, error) {
+	imageName, err := resolveImageName(imageName)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	image, _, err := dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return "", nil
+		}
+		return "", traceutility.Wrap(err)
+	}
+
+	return image.ID, nil
+}
+
 func ImageRemove(imageID string) error {
 	_, err := dockerClient.ImageRemove(ctx, imageID, types.ImageRemoveOptions{})
 	if err != nil {
@@ -72,6 +275,25 @@ func ImageRemove(imageID string) error {
 	return nil
 }
 
+// ImageRemoveAllTags removes the image even if it is tagged in several repositories
+func ImageRemoveAllTags(imageID string) error {
+	_, err := dockerClient.ImageRemove(ctx, imageID, types.ImageRemoveOptions{Force: true, PruneChildren: true})
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
+
+func ReadAllImages() ([]types.ImageSummary, error) {
+	images, err := dockerClient.ImageList(ctx, types.ImageListOptions{All: false})
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return images, nil
+}
+
 func GetImagesByName(images []string) ([]types.ImageSummary, error) {
 	if len(images) == 0 {
 		return nil, nil
@@ -79,9 +301,63 @@ func GetImagesByName(images []string) ([]types.ImageSummary, error) {
 
 	filter := filters.NewArgs()
 	for _, image := range images {
-		filter.Add("reference", image)
+		imageName, err := resolveImageName(image)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		filter.Add("reference", imageName)
 	}
 	options := types.ImageListOptions{Filters: filter}
 
 	return dockerClient.ImageList(ctx, options)
 }
+
+// ImageHasDigest checks if the local image was pulled from a repository with the given manifest digest
+func ImageHasDigest(imageName string, digest string) (bool, error) {
+	imageName, err := resolveImageName(imageName)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	image, _, err := dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return false, traceutility.Wrap(err)
+	}
+
+	return hasRepoDigest(image.RepoDigests, digest), nil
+}
+
+// LoadImage loads the images of an archive created by docker save and returns the names of the loaded images
+func LoadImage(archive io.Reader) ([]string, error) {
+	response, err := dockerClient.ImageLoad(ctx, archive, true)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	defer response.Body.Close()
+
+	d := json.NewDecoder(response.Body)
+
+	var loaded []string
+	for {
+		var event jsonmessage.JSONMessage
+		if err := d.Decode(&event); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, traceutility.Wrap(err)
+		}
+
+		if event.Error != nil {
+			return nil, traceutility.Wrap(event.Error)
+		}
+
+		stream := strings.TrimSpace(event.Stream)
+		if name, ok := strings.CutPrefix(stream, "Loaded image: "); ok {
+			loaded = append(loaded, name)
+		} else if id, ok := strings.CutPrefix(stream, "Loaded image ID: "); ok {
+			loaded = append(loaded, id)
+		}
+	}
+
+	return loaded, nil
+}
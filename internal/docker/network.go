@@ -48,6 +48,19 @@ func ReadEdgeAppNetworks(manifestUniqueID model.ManifestUniqueID) ([]types.Netwo
 	return networks, nil
 }
 
+// ReadAllEdgeAppNetworks returns the networks of all edge apps, including the ones not known to the agent
+func ReadAllEdgeAppNetworks() ([]types.NetworkResource, error) {
+	filter := filters.NewArgs()
+	filter.Add("label", "manifestUniqueID")
+
+	networks, err := dockerClient.NetworkList(ctx, types.NetworkListOptions{Filters: filter})
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return networks, nil
+}
+
 func makeNetworkName(name string) (string, error) {
 	format := "%s_%0" + strconv.Itoa(indexLength) + "d"
 
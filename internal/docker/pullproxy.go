@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	log "github.com/sirupsen/logrus"
+
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// limitedReadSize is the most a limited response is read at once, so that the limiter waits in small steps
+const limitedReadSize = 32 * 1024
+
+// certsDir holds the certificates the docker daemon uses for registries, one directory per registry host
+const certsDir = "/etc/docker/certs.d"
+
+// registryHosts are the hosts of the registry API for the registry domains that differ from it
+var registryHosts = map[string]string{"docker.io": "registry-1.docker.io"}
+
+// BandwidthLimiter limits the total download rate of concurrent image pulls
+type BandwidthLimiter struct {
+	mu   sync.Mutex
+	rate float64 // bytes per second
+	next time.Time
+}
+
+// NewBandwidthLimiter returns a limiter for the rate in KB/s, or nil if the rate is not limited
+func NewBandwidthLimiter(rate int) *BandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &BandwidthLimiter{rate: float64(rate) * 1024}
+}
+
+// wait blocks until the downloaded bytes fit into the rate limit
+func (l *BandwidthLimiter) wait(bytes int64) {
+	if l == nil || bytes <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(bytes) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// pullImageThroughProxy pulls the image through a pull proxy for its registry, which limits the download rate.
+// The image is pulled directly without a limit if the proxy cannot connect to the registry the way the daemon would,
+// or if the daemon cannot reach the proxy, e.g. because the agent runs in another network namespace.
+func pullImageThroughProxy(authConfig types.AuthConfig, named reference.Named, limiter *BandwidthLimiter, progress func(PullProgress)) error {
+	domain := reference.Domain(named)
+	reason, err := proxyUnsupported(domain)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if reason != "" {
+		log.Warnf("Pulling image %v without bandwidth limit, %v", named, reason)
+		return pullImage(authConfig, named.String(), progress)
+	}
+
+	registryHost := domain
+	if host, ok := registryHosts[registryHost]; ok {
+		registryHost = host
+	}
+
+	proxy, err := startPullProxy(registryHost, limiter, http.DefaultTransport)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer proxy.Close()
+
+	err = pullImageFromHost(authConfig, named, proxy.Host(), progress)
+	if err != nil && !proxy.Reached() {
+		log.Warnf("The docker daemon could not reach the pull proxy, pulling image %v without bandwidth limit. "+
+			"The agent has to run in the network namespace of the docker daemon to limit pulls. CAUSE --> %v", named, err)
+		return pullImage(authConfig, named.String(), progress)
+	}
+	return err
+}
+
+// proxyUnsupported returns why the registry cannot be pulled from through the proxy, or an empty string if it can.
+// The proxy connects to the registry over HTTPS with the CAs of the host, it does not know the insecure registries
+// and the certificates (certs.d) the daemon is configured with.
+func proxyUnsupported(domain string) (string, error) {
+	info, err := dockerClient.Info(ctx)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	ips, _ := net.LookupIP(host)
+
+	return registryConfigUnsupported(info.RegistryConfig, domain, ips, certsDir), nil
+}
+
+func registryConfigUnsupported(registryConfig *registry.ServiceConfig, domain string, ips []net.IP, certsDir string) string {
+	if _, err := os.Stat(filepath.Join(certsDir, domain)); err == nil {
+		return "the docker daemon has certificates for the registry in " + filepath.Join(certsDir, domain)
+	}
+
+	if registryConfig == nil {
+		return ""
+	}
+	if index, ok := registryConfig.IndexConfigs[domain]; ok && !index.Secure {
+		return "the docker daemon treats the registry as insecure"
+	}
+	for _, cidr := range registryConfig.InsecureRegistryCIDRs {
+		for _, ip := range ips {
+			if (*net.IPNet)(cidr).Contains(ip) {
+				return "the docker daemon treats the registry as insecure"
+			}
+		}
+	}
+
+	return ""
+}
+
+// pullProxy forwards the registry requests of the docker daemon to the registry and reads the responses no faster
+// than the limiter allows, which holds back the download of the daemon through TCP flow control.
+// It listens on the loopback interface, the daemon pulls from registries on 127.0.0.0/8 over plain HTTP
+// without being configured for it. Redirects, e.g. of layer downloads to a CDN, are followed by the proxy,
+// so that these downloads are limited as well.
+type pullProxy struct {
+	listener net.Listener
+	server   *http.Server
+	reached  atomic.Bool // set when the daemon sent the first request
+}
+
+func startPullProxy(registryHost string, limiter *BandwidthLimiter, transport http.RoundTripper) (*pullProxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	upstream := &http.Client{Transport: transport}
+	handler := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "https"
+			req.URL.Host = registryHost
+			req.Host = registryHost
+			req.RequestURI = ""
+		},
+		Transport: roundTripperFunc(upstream.Do),
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Body = &limitedReader{ReadCloser: resp.Body, limiter: limiter}
+			return nil
+		},
+		FlushInterval: -1,
+	}
+
+	proxy := &pullProxy{listener: listener}
+	proxy.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			proxy.reached.Store(true)
+			handler.ServeHTTP(w, req)
+		}),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go proxy.server.Serve(listener)
+
+	return proxy, nil
+}
+
+// Host returns the address the daemon pulls from
+func (p *pullProxy) Host() string {
+	return p.listener.Addr().String()
+}
+
+// Reached tells if the daemon connected to the proxy
+func (p *pullProxy) Reached() bool {
+	return p.reached.Load()
+}
+
+func (p *pullProxy) Close() error {
+	return p.server.Close()
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// limitedReader waits for the limiter after every read
+type limitedReader struct {
+	io.ReadCloser
+	limiter *BandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedReadSize {
+		p = p[:limitedReadSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.limiter.wait(int64(n))
+	return n, err
+}
@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	layer := strings.Repeat("x", 64*1024)
+	var authorization string
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/app/blobs/sha256:1":
+			authorization = r.Header.Get("Authorization")
+			http.Redirect(w, r, "/cdn/1", http.StatusTemporaryRedirect)
+		case "/cdn/1":
+			io.WriteString(w, layer)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer registry.Close()
+
+	registryURL, err := url.Parse(registry.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 128 KB/s, the layer takes half a second
+	proxy, err := startPullProxy(registryURL.Host, NewBandwidthLimiter(128), registry.Client().Transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	assert.False(proxy.Reached())
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+proxy.Host()+"/v2/library/app/blobs/sha256:1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the redirect is followed by the proxy, not by the daemon
+	assert.True(proxy.Reached())
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal(layer, string(body))
+	assert.Equal("Bearer token", authorization)
+	assert.GreaterOrEqual(time.Since(start), 400*time.Millisecond)
+
+	resp, err = http.Get("http://" + proxy.Host() + "/v2/library/missing/manifests/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRegistryConfigUnsupported(t *testing.T) {
+	certsDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(certsDir, "private.example.com:5000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, insecureNet, _ := net.ParseCIDR("10.0.0.0/8")
+	registryConfig := &registry.ServiceConfig{
+		InsecureRegistryCIDRs: []*registry.NetIPNet{(*registry.NetIPNet)(loopback), (*registry.NetIPNet)(insecureNet)},
+		IndexConfigs: map[string]*registry.IndexInfo{
+			"docker.io":                 {Name: "docker.io", Secure: true},
+			"insecure.example.com:5000": {Name: "insecure.example.com:5000", Secure: false},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		domain      string
+		ips         []net.IP
+		unsupported bool
+	}{
+		{"secure registry", "docker.io", []net.IP{net.ParseIP("203.0.113.10")}, false},
+		{"insecure registry", "insecure.example.com:5000", nil, true},
+		{"registry in an insecure network", "registry.local", []net.IP{net.ParseIP("10.1.2.3")}, true},
+		{"registry with certificates", "private.example.com:5000", []net.IP{net.ParseIP("203.0.113.11")}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := registryConfigUnsupported(registryConfig, test.domain, test.ips, certsDir)
+			assert.Equal(t, test.unsupported, reason != "", reason)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package docker
+
+import (
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// RemoveEdgeAppVolumes removes the named volumes owned by the edge app and with them all the data of the edge app
+func RemoveEdgeAppVolumes(manifestUniqueID model.ManifestUniqueID) error {
+	filter := filters.NewArgs()
+	filter.Add("label", "manifestUniqueID="+manifestUniqueID.String())
+
+	volumes, err := dockerClient.VolumeList(ctx, filter)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	for _, volume := range volumes.Volumes {
+		err := dockerClient.VolumeRemove(ctx, volume.Name, false)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Removed volume ", volume.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package edgeapp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const (
+	mb               = 1024 * 1024
+	defaultCPUPeriod = 100000
+)
+
+// resourceUsage holds the resources reserved by edge app modules or available on the node
+type resourceUsage struct {
+	nanoCPUs int64
+	memory   int64 // bytes
+	disk     int64 // bytes
+}
+
+// checkAdmission rejects an edge app if the resources requested by its modules together with the
+// resources reserved by the other known edge apps exceed the capacity of the node
+func checkAdmission(man manifest.Manifest) error {
+	requested := manifestResources(man)
+	if requested == (resourceUsage{}) {
+		return nil
+	}
+
+	reserved := reservedResources(manifest.GetKnownManifests(), man.UniqueID)
+
+	params, err := getDeviceParams()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	capacity, diskFree := nodeCapacity(params)
+
+	return admit(man.UniqueID, requested, reserved, capacity, diskFree)
+}
+
+// reservedResources sums up the resources of the known edge apps, except for the undeployed ones
+// and the previous version of the edge app that is going to be replaced
+func reservedResources(knownManifests map[model.ManifestUniqueID]*manifest.ManifestRecord, replaced model.ManifestUniqueID) resourceUsage {
+	var reserved resourceUsage
+	for uniqueID, record := range knownManifests {
+		if uniqueID == replaced || record.Status == model.EdgeAppUndeployed {
+			continue
+		}
+		usage := manifestResources(record.Manifest)
+		reserved.nanoCPUs += usage.nanoCPUs
+		reserved.memory += usage.memory
+		reserved.disk += usage.disk
+	}
+	return reserved
+}
+
+// admit returns an error listing every resource the edge app requests beyond what is not reserved on the node
+func admit(uniqueID model.ManifestUniqueID, requested resourceUsage, reserved resourceUsage, capacity resourceUsage, diskFree int64) error {
+	var reasons []string
+	if requested.nanoCPUs > 0 && reserved.nanoCPUs+requested.nanoCPUs > capacity.nanoCPUs {
+		reasons = append(reasons, fmt.Sprintf("requested %.2f CPUs, but only %.2f of %.2f CPUs are not reserved",
+			float64(requested.nanoCPUs)/1e9, float64(capacity.nanoCPUs-reserved.nanoCPUs)/1e9, float64(capacity.nanoCPUs)/1e9))
+	}
+	if requested.memory > 0 && reserved.memory+requested.memory > capacity.memory {
+		reasons = append(reasons, fmt.Sprintf("requested %vMB memory, but only %vMB of %vMB are not reserved",
+			requested.memory/mb, (capacity.memory-reserved.memory)/mb, capacity.memory/mb))
+	}
+	if requested.disk > 0 && reserved.disk+requested.disk > capacity.disk {
+		reasons = append(reasons, fmt.Sprintf("requested %vMB disk space, but only %vMB of %vMB are not reserved",
+			requested.disk/mb, (capacity.disk-reserved.disk)/mb, capacity.disk/mb))
+	} else if requested.disk > diskFree {
+		reasons = append(reasons, fmt.Sprintf("requested %vMB disk space, but only %vMB are free", requested.disk/mb, diskFree/mb))
+	}
+
+	if len(reasons) > 0 {
+		return fmt.Errorf("edge app %v rejected by admission control: %v", uniqueID, strings.Join(reasons, "; "))
+	}
+
+	return nil
+}
+
+// manifestResources sums up the resources requested by the modules, a module that does not request CPUs or memory
+// reserves the configured default request, so that edge apps without requests cannot bypass admission control
+func manifestResources(man manifest.Manifest) resourceUsage {
+	var usage resourceUsage
+	for _, module := range man.Modules {
+		nanoCPUs := moduleNanoCPUs(module.Resources)
+		if nanoCPUs == 0 {
+			nanoCPUs = int64(config.Params.ModuleDefaultCPUs * 1e9)
+		}
+		memory := module.Resources.Memory
+		if memory == 0 {
+			memory = config.Params.ModuleDefaultMem * mb
+		}
+
+		usage.nanoCPUs += nanoCPUs
+		usage.memory += memory
+		usage.disk += module.DiskRequest
+	}
+	return usage
+}
+
+func moduleNanoCPUs(resources container.Resources) int64 {
+	if resources.NanoCPUs > 0 {
+		return resources.NanoCPUs
+	}
+	if resources.CPUQuota > 0 {
+		period := resources.CPUPeriod
+		if period == 0 {
+			period = defaultCPUPeriod
+		}
+		return resources.CPUQuota * 1e9 / period
+	}
+	return 0
+}
+
+// nodeCapacity returns the capacity of the node from the device params reported to the manager,
+// so that the agent admits what the manager sees, and the currently free disk space
+func nodeCapacity(params com.DeviceParamsMsg) (resourceUsage, int64) {
+	capacity := resourceUsage{
+		nanoCPUs: int64(params.CPUCapacity * 1e9),
+		memory:   params.RamCapacity,
+		disk:     params.StorageCapacity,
+	}
+	return capacity, params.StorageFreeAvailable
+}
@@ -0,0 +1,132 @@
+package edgeapp
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+)
+
+func TestManifestResources(t *testing.T) {
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+	config.Params.ModuleDefaultCPUs = 0.1
+	config.Params.ModuleDefaultMem = 64
+
+	tests := []struct {
+		name      string
+		resources []container.Resources
+		disk      []int64
+		expected  resourceUsage
+	}{
+		{"no requests reserve the defaults", []container.Resources{{}}, []int64{0}, resourceUsage{nanoCPUs: 0.1e9, memory: 64 * mb}},
+		{"nano cpus", []container.Resources{{NanoCPUs: 1.5e9, Memory: mb}}, []int64{0}, resourceUsage{nanoCPUs: 1.5e9, memory: mb}},
+		{"cpu quota with default period", []container.Resources{{CPUQuota: 50000, Memory: mb}}, []int64{0}, resourceUsage{nanoCPUs: 0.5e9, memory: mb}},
+		{"cpu quota with period", []container.Resources{{CPUQuota: 50000, CPUPeriod: 200000, Memory: mb}}, []int64{0}, resourceUsage{nanoCPUs: 0.25e9, memory: mb}},
+		{"nano cpus before quota", []container.Resources{{NanoCPUs: 2e9, CPUQuota: 50000, Memory: mb}}, []int64{0}, resourceUsage{nanoCPUs: 2e9, memory: mb}},
+		{"memory only", []container.Resources{{Memory: 128 * mb}}, []int64{0}, resourceUsage{nanoCPUs: 0.1e9, memory: 128 * mb}},
+		{
+			"modules are summed up",
+			[]container.Resources{{NanoCPUs: 1e9, Memory: 64 * mb}, {NanoCPUs: 0.5e9, Memory: 128 * mb}},
+			[]int64{10 * mb, 20 * mb},
+			resourceUsage{nanoCPUs: 1.5e9, memory: 192 * mb, disk: 30 * mb},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var man manifest.Manifest
+			for i, resources := range test.resources {
+				man.Modules = append(man.Modules, manifest.ContainerConfig{Resources: resources, DiskRequest: test.disk[i]})
+			}
+			assert.Equal(t, test.expected, manifestResources(man))
+		})
+	}
+}
+
+func TestReservedResources(t *testing.T) {
+	app := func(status string, nanoCPUs int64, memory int64, disk int64) *manifest.ManifestRecord {
+		return &manifest.ManifestRecord{
+			Manifest: manifest.Manifest{Modules: []manifest.ContainerConfig{{
+				Resources:   container.Resources{NanoCPUs: nanoCPUs, Memory: memory},
+				DiskRequest: disk,
+			}}},
+			Status: status,
+		}
+	}
+	known := map[model.ManifestUniqueID]*manifest.ManifestRecord{
+		{ID: "running"}:    app(model.EdgeAppRunning, 1e9, 100*mb, 10*mb),
+		{ID: "stopped"}:    app(model.EdgeAppStopped, 0.5e9, 50*mb, 0),
+		{ID: "undeployed"}: app(model.EdgeAppUndeployed, 4e9, 1000*mb, 1000*mb),
+	}
+
+	tests := []struct {
+		name     string
+		replaced model.ManifestUniqueID
+		expected resourceUsage
+	}{
+		{"new edge app", model.ManifestUniqueID{ID: "new"}, resourceUsage{nanoCPUs: 1.5e9, memory: 150 * mb, disk: 10 * mb}},
+		{"new version of a running edge app", model.ManifestUniqueID{ID: "running"}, resourceUsage{nanoCPUs: 0.5e9, memory: 50 * mb}},
+		{"new version of an undeployed edge app", model.ManifestUniqueID{ID: "undeployed"}, resourceUsage{nanoCPUs: 1.5e9, memory: 150 * mb, disk: 10 * mb}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, reservedResources(known, test.replaced))
+		})
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	capacity := resourceUsage{nanoCPUs: 4e9, memory: 1024 * mb, disk: 1000 * mb}
+	reserved := resourceUsage{nanoCPUs: 3e9, memory: 512 * mb, disk: 500 * mb}
+
+	tests := []struct {
+		name      string
+		requested resourceUsage
+		diskFree  int64
+		reasons   []string
+	}{
+		{"fits", resourceUsage{nanoCPUs: 1e9, memory: 512 * mb, disk: 500 * mb}, 800 * mb, nil},
+		{"too many cpus", resourceUsage{nanoCPUs: 1.5e9}, 800 * mb, []string{"requested 1.50 CPUs, but only 1.00 of 4.00 CPUs are not reserved"}},
+		{"too much memory", resourceUsage{memory: 513 * mb}, 800 * mb, []string{"requested 513MB memory, but only 512MB of 1024MB are not reserved"}},
+		{"too much disk", resourceUsage{disk: 501 * mb}, 800 * mb, []string{"requested 501MB disk space, but only 500MB of 1000MB are not reserved"}},
+		{"not enough free disk", resourceUsage{disk: 400 * mb}, 300 * mb, []string{"requested 400MB disk space, but only 300MB are free"}},
+		{
+			"all resources",
+			resourceUsage{nanoCPUs: 2e9, memory: 1024 * mb, disk: 600 * mb},
+			800 * mb,
+			[]string{"CPUs are not reserved", "memory, but only", "disk space, but only 500MB"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := admit(model.ManifestUniqueID{ID: "app"}, test.requested, reserved, capacity, test.diskFree)
+			if test.reasons == nil {
+				assert.Nil(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				for _, reason := range test.reasons {
+					assert.Contains(t, err.Error(), reason)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeCapacity(t *testing.T) {
+	capacity, diskFree := nodeCapacity(com.DeviceParamsMsg{
+		CPUCapacity:          2.5,
+		RamCapacity:          2048 * mb,
+		StorageCapacity:      10000 * mb,
+		StorageFreeAvailable: 4000 * mb,
+	})
+	assert.Equal(t, resourceUsage{nanoCPUs: 2.5e9, memory: 2048 * mb, disk: 10000 * mb}, capacity)
+	assert.Equal(t, int64(4000*mb), diskFree)
+}
@@ -20,6 +20,10 @@ const (
 	CMDResume   = "RESUME"
 	CMDUndeploy = "UNDEPLOY"
 	CMDRemove   = "REMOVE"
+	CMDPrefetch = "PREFETCH"
+	CMDAdopt    = "ADOPT"
+	CMDCleanup  = "CLEANUP"
+	CMDRollback = "ROLLBACK"
 )
 
 func DeployEdgeApp(man manifest.Manifest) error {
@@ -27,7 +31,13 @@ func DeployEdgeApp(man manifest.Manifest) error {
 
 	log.Info(deploymentID, "Deploying edge app ...")
 
-	//******** STEP 1 - Check if a version of the edge app is already deployed *************//
+	//******** STEP 1 - Check if the node can host the edge app and if a version of it is already deployed *************//
+	err := checkAdmission(man)
+	if err != nil {
+		log.Error(deploymentID, "Deployment rejected! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+
 	edgeAppRecord := manifest.GetKnownManifest(man.UniqueID)
 	if edgeAppRecord != nil && edgeAppRecord.Status != model.EdgeAppUndeployed {
 		if edgeAppRecord.Manifest.UpdatedAt.Before(man.UpdatedAt) {
@@ -36,7 +46,7 @@ func DeployEdgeApp(man manifest.Manifest) error {
 			for _, module := range man.Modules {
 				newImages = append(newImages, module.ImageNameFull)
 			}
-			RemoveEdgeApp(man.UniqueID, newImages)
+			removeEdgeApp(man.UniqueID, newImages, false)
 		} else {
 			return errors.New("edge app " + man.UniqueID.String() + " already exist")
 		}
@@ -44,30 +54,29 @@ func DeployEdgeApp(man manifest.Manifest) error {
 
 	manifest.AddKnownManifest(man)
 
-	//******** STEP 2 - Pull all images *************//
-	log.Info(deploymentID, "Iterating modules, pulling image into host if missing ...")
+	// the images must not be garbage collected before the containers are created
+	imageLock.Lock()
+	defer imageLock.Unlock()
 
-	for _, module := range man.Modules {
-		// Check if image exist in local
-		exists, err := docker.ImageExists(module.ImageNameFull)
-		if err != nil {
-			log.Error(deploymentID, "Deployment failed! CAUSE --> ", err)
-			return traceutility.Wrap(err)
-		}
-		if exists { // Image already exists, continue
-			log.Info(deploymentID, fmt.Sprintf("Image %v, already exists on host", module.ImageNameFull))
-		} else { // Pull this image
-			log.Info(deploymentID, fmt.Sprintf("Image %v, does not exist on host", module.ImageNameFull))
-			log.Info(deploymentID, "Pulling ", module.ImageNameFull)
-			err = docker.PullImage(module.AuthConfig, module.ImageNameFull)
-			if err != nil {
-				log.Error(deploymentID, "Unable to pull image/s, "+err.Error())
-				setAndSendStatus(man.UniqueID, model.EdgeAppError)
-				log.Info(deploymentID, "Initiating rollback ...")
-				RemoveEdgeApp(man.UniqueID, nil)
-				return errors.New("unable to pull image/s")
-			}
-		}
+	//******** STEP 2 - Pull and verify all images *************//
+	log.Info(deploymentID, "Iterating modules, importing or pulling image into host if missing ...")
+
+	err = pullImages(man)
+	if err != nil {
+		log.Error(deploymentID, "Unable to pull image/s, "+err.Error())
+		setAndSendStatus(man.UniqueID, model.EdgeAppError)
+		log.Info(deploymentID, "Initiating rollback ...")
+		removeEdgeApp(man.UniqueID, nil, false)
+		return errors.New("unable to pull image/s")
+	}
+
+	err = verifyImages(man)
+	if err != nil {
+		log.Error(deploymentID, "Image verification failed! CAUSE --> ", err)
+		setAndSendStatus(man.UniqueID, model.EdgeAppError)
+		log.Info(deploymentID, "Initiating rollback ...")
+		removeEdgeApp(man.UniqueID, nil, false)
+		return traceutility.Wrap(err)
 	}
 
 	//******** STEP 3 - Create the network *************//
@@ -78,7 +87,7 @@ func DeployEdgeApp(man manifest.Manifest) error {
 		log.Error("CreateNetwork failed! CAUSE --> ", err)
 		setAndSendStatus(man.UniqueID, model.EdgeAppError)
 		log.Info(deploymentID, "Initiating rollback ...")
-		RemoveEdgeApp(man.UniqueID, nil)
+		removeEdgeApp(man.UniqueID, nil, false)
 		return traceutility.Wrap(err)
 	}
 
@@ -87,6 +96,15 @@ func DeployEdgeApp(man manifest.Manifest) error {
 	log.Info(deploymentID, "Created network >> ", networkName)
 
 	//******** STEP 4 - Create, Start, attach all containers *************//
+	err = writeSecretFiles(man)
+	if err != nil {
+		log.Error(deploymentID, "Failed to write secret files! CAUSE --> ", err)
+		setAndSendStatus(man.UniqueID, model.EdgeAppError)
+		log.Info(deploymentID, "Initiating rollback ...")
+		removeEdgeApp(man.UniqueID, nil, false)
+		return traceutility.Wrap(err)
+	}
+
 	log.Info(deploymentID, "Starting all containers ...")
 	containerConfigs := man.Modules
 
@@ -94,7 +112,7 @@ func DeployEdgeApp(man manifest.Manifest) error {
 		log.Error(deploymentID, "No valid containers in Manifest")
 		setAndSendStatus(man.UniqueID, model.EdgeAppError)
 		log.Info(deploymentID, "Initiating rollback ...")
-		RemoveEdgeApp(man.UniqueID, nil)
+		removeEdgeApp(man.UniqueID, nil, false)
 		return errors.New("no valid contianers in manifest")
 	}
 
@@ -105,7 +123,7 @@ func DeployEdgeApp(man manifest.Manifest) error {
 		if err != nil {
 			log.Error(deploymentID, "Failed to create and start container ", containerConfigs[i].ContainerName, " CAUSE --> ", err)
 			log.Info(deploymentID, "Initiating rollback ...")
-			RemoveEdgeApp(man.UniqueID, nil)
+			removeEdgeApp(man.UniqueID, nil, false)
 			setAndSendStatus(man.UniqueID, model.EdgeAppError)
 			return traceutility.Wrap(err)
 		}
@@ -115,9 +133,131 @@ func DeployEdgeApp(man manifest.Manifest) error {
 
 	setAndSendStatus(man.UniqueID, model.EdgeAppRunning)
 
+	err = manifest.AddHistory(man.UniqueID)
+	if err != nil {
+		log.Error(deploymentID, "Failed to add the edge app to the deployment history! CAUSE --> ", err)
+	}
+
+	if staged, ok := manifest.GetStagedManifest(man.UniqueID); ok && !staged.UpdatedAt.After(man.UpdatedAt) {
+		manifest.DeleteStagedManifest(man.UniqueID)
+	}
+
 	return nil
 }
 
+// PrefetchEdgeApp pulls the images of the edge app without deploying it,
+// so that a later deployment only has to create the containers
+func PrefetchEdgeApp(man manifest.Manifest) error {
+	prefetchID := man.UniqueID.String() + " | "
+
+	log.Info(prefetchID, "Prefetching edge app ...")
+
+	// the images must not be garbage collected before the manifest is staged
+	imageLock.Lock()
+	defer imageLock.Unlock()
+
+	edgeAppRecord := manifest.GetKnownManifest(man.UniqueID)
+	if edgeAppRecord != nil && edgeAppRecord.Status != model.EdgeAppUndeployed && !edgeAppRecord.Manifest.UpdatedAt.Before(man.UpdatedAt) {
+		return errors.New("edge app " + man.UniqueID.String() + " is already deployed in this or a newer version")
+	}
+
+	err := pullImages(man)
+	if err != nil {
+		log.Error(prefetchID, "Unable to pull image/s, "+err.Error())
+		return traceutility.Wrap(err)
+	}
+
+	err = verifyImages(man)
+	if err != nil {
+		log.Error(prefetchID, "Image verification failed! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+
+	err = manifest.AddStagedManifest(man)
+	if err != nil {
+		log.Error(prefetchID, "Failed to stage the edge app! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+	log.Info(prefetchID, "Edge app staged, images are ready for deployment")
+
+	return SendStatus()
+}
+
+// RestoreEdgeApps recreates the running edge apps whose containers are missing, e.g. after the docker data was lost,
+// from the known manifests and their sealed secret values, without waiting for the manager to send them again.
+// An edge app whose redeployment failed is kept with the error status and redeployed again on the next start.
+func RestoreEdgeApps() {
+	var missing []manifest.Manifest
+	for uniqueID, record := range manifest.GetKnownManifests() {
+		if record.Status != model.EdgeAppRunning && record.Status != model.EdgeAppError {
+			continue
+		}
+
+		containers, err := docker.ReadEdgeAppContainers(uniqueID)
+		if err != nil {
+			log.Error("Failed to read edge app containers! CAUSE --> ", err)
+			continue
+		}
+		if len(containers) < len(record.Manifest.Modules) {
+			if record.Rebuilt {
+				log.Warnf("Containers of edge app %v are missing, but its manifest was rebuilt and cannot be redeployed", uniqueID)
+				continue
+			}
+			missing = append(missing, record.Manifest)
+			continue
+		}
+		if record.Status != model.EdgeAppRunning {
+			continue
+		}
+
+		// the secret files are gone after a reboot, the containers could not be started without them
+		err = restoreSecretFiles(uniqueID)
+		if err != nil {
+			log.Error("Failed to restore secret files of edge app ", uniqueID, "! CAUSE --> ", err)
+			continue
+		}
+		for _, container := range containers {
+			if container.State != strings.ToLower(model.ModuleRunning) {
+				err := docker.StartContainer(container.ID)
+				if err != nil {
+					log.Error("Could not start container ", strings.Join(container.Names, ","), "! CAUSE --> ", err)
+				}
+			}
+		}
+	}
+
+	for _, storedManifest := range missing {
+		restoreID := storedManifest.UniqueID.String() + " | "
+		log.Info(restoreID, "Containers of the edge app are missing, redeploying it ...")
+
+		// without its secret values the edge app would be started with a broken configuration
+		man, err := manifest.UnsealSecretValues(storedManifest)
+		if err != nil {
+			log.Error(restoreID, "Restoring edge app failed! CAUSE --> ", err)
+			continue
+		}
+
+		var images []string
+		for _, module := range man.Modules {
+			images = append(images, module.ImageNameFull)
+		}
+		err = removeEdgeApp(man.UniqueID, images, false)
+		if err != nil {
+			log.Error(restoreID, "Restoring edge app failed! CAUSE --> ", err)
+			continue
+		}
+
+		err = DeployEdgeApp(man)
+		if err != nil {
+			log.Error(restoreID, "Restoring edge app failed! CAUSE --> ", err)
+
+			// the failed deployment removed the known manifest, keep it to retry on the next start
+			manifest.AddKnownManifest(man)
+			setAndSendStatus(man.UniqueID, model.EdgeAppError)
+		}
+	}
+}
+
 func StopEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 	log.Infoln("Stopping edge app:", manifestUniqueID)
 
@@ -159,6 +299,13 @@ func StopEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 		}
 	}
 
+	err = removeSecretFiles(manifestUniqueID)
+	if err != nil {
+		log.Error("Could not wipe the secret files! CAUSE --> ", err)
+		setAndSendStatus(manifestUniqueID, model.EdgeAppError)
+		return traceutility.Wrap(err)
+	}
+
 	setAndSendStatus(manifestUniqueID, model.EdgeAppStopped)
 
 	return nil
@@ -190,6 +337,13 @@ func ResumeEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 
 	setAndSendStatus(manifestUniqueID, model.EdgeAppExecuting)
 
+	err = restoreSecretFiles(manifestUniqueID)
+	if err != nil {
+		log.Error("Could not restore the secret files! CAUSE --> ", err)
+		setAndSendStatus(manifestUniqueID, model.EdgeAppError)
+		return traceutility.Wrap(err)
+	}
+
 	// start containers in reverse order to prevent connectivity issues
 	for i := len(containers) - 1; i >= 0; i-- {
 		if containers[i].State != strings.ToLower(model.ModuleRunning) {
@@ -245,6 +399,13 @@ func UndeployEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 		}
 	}
 
+	err = removeSecretFiles(manifestUniqueID)
+	if err != nil {
+		log.Errorf("Undeployment failed! UndeploymentID --> %s, CAUSE --> %v", undeploymentID, err)
+		setAndSendStatus(manifestUniqueID, model.EdgeAppError)
+		errorlist = fmt.Sprintf("%v,%v", errorlist, err)
+	}
+
 	//******** STEP 2 - Remove Network *************//
 	log.Info(undeploymentID, "Pruning networks ...")
 
@@ -264,7 +425,22 @@ func UndeployEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
 	return nil
 }
 
+// RemoveEdgeApp undeploys the edge app and removes its images, data and manifest from the node
 func RemoveEdgeApp(manifestUniqueID model.ManifestUniqueID, keepImages []string) error {
+	if _, staged := manifest.GetStagedManifest(manifestUniqueID); staged {
+		manifest.DeleteStagedManifest(manifestUniqueID)
+		if manifest.GetKnownManifest(manifestUniqueID) == nil {
+			log.Infoln("Removed staged edge app:", manifestUniqueID)
+			return SendStatus()
+		}
+	}
+
+	return removeEdgeApp(manifestUniqueID, keepImages, true)
+}
+
+// removeEdgeApp keeps the data volumes of the edge app unless removeData is set,
+// so that the data survives a version update or a failed deployment
+func removeEdgeApp(manifestUniqueID model.ManifestUniqueID, keepImages []string, removeData bool) error {
 	log.Infoln("Removing edge app:", manifestUniqueID)
 
 	removalID := manifestUniqueID.String() + " | "
@@ -284,7 +460,15 @@ func RemoveEdgeApp(manifestUniqueID model.ManifestUniqueID, keepImages []string)
 		return traceutility.Wrap(err)
 	}
 
-	// make sure that the images that should be kept are not removed
+	// the images of the previous versions are kept to roll back to, unless the edge app is removed completely
+	if removeData {
+		usedImageNames = append(usedImageNames, manifest.GetHistoryImages(manifestUniqueID)...)
+	} else {
+		keepImages = append(keepImages, manifest.GetHistoryImages(manifestUniqueID)...)
+	}
+
+	// make sure that the images that should be kept, including the prefetched ones, are not removed
+	keepImages = append(keepImages, manifest.GetStagedImages()...)
 	var removeImageNames []string
 	if len(keepImages) > 0 {
 		removeImageNames = subtractArray(usedImageNames, keepImages)
@@ -338,7 +522,23 @@ func RemoveEdgeApp(manifestUniqueID model.ManifestUniqueID, keepImages []string)
 		}
 	}
 
-	//******** STEP 3 - Remove Manifest *************//
+	//******** STEP 3 - Remove Data *************//
+	if removeData {
+		log.Info(removalID, "Removing volumes ...")
+		err = docker.RemoveEdgeAppVolumes(manifestUniqueID)
+		if err != nil {
+			log.Errorf("Edge app removal failed! RemovalID --> %s, CAUSE --> %v", removalID, err)
+			setAndSendStatus(manifestUniqueID, model.EdgeAppError)
+			return traceutility.Wrap(err)
+		}
+
+		err = manifest.DeleteHistory(manifestUniqueID)
+		if err != nil {
+			log.Errorf("Failed to delete the deployment history! RemovalID --> %s, CAUSE --> %v", removalID, err)
+		}
+	}
+
+	//******** STEP 4 - Remove Manifest *************//
 	manifest.DeleteKnownManifest(manifestUniqueID)
 	err = SendStatus()
 	if err != nil {
@@ -352,6 +552,10 @@ func RemoveEdgeApp(manifestUniqueID model.ManifestUniqueID, keepImages []string)
 func RemoveAll() error {
 	log.Info("Removing all edge apps")
 
+	for uniqueID := range manifest.GetStagedManifests() {
+		manifest.DeleteStagedManifest(uniqueID)
+	}
+
 	for uniqueID := range manifest.GetKnownManifests() {
 		err := RemoveEdgeApp(uniqueID, nil)
 		if err != nil {
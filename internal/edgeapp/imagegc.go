@@ -0,0 +1,175 @@
+package edgeapp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/shirou/gopsutil/v3/disk"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// imageLock prevents the garbage collection from removing images that are pulled but not yet used by a container or a staged manifest
+var imageLock sync.Mutex
+
+var imageGCReport com.ImageGCMsg
+var imageGCReportLock sync.Mutex
+
+func getImageGCReport() com.ImageGCMsg {
+	imageGCReportLock.Lock()
+	defer imageGCReportLock.Unlock()
+	return imageGCReport
+}
+
+// CollectImageGarbage removes the images that are not used by any container or known manifest when the disk usage
+// is above the high watermark, oldest first, until the disk usage drops below the low watermark.
+// The latest ImageGCKeep versions of every repository are kept.
+func CollectImageGarbage() {
+	imageLock.Lock()
+	defer imageLock.Unlock()
+
+	report := com.ImageGCMsg{LastRun: time.Now().UTC(), RemovedImages: []string{}}
+	err := collectImageGarbage(&report)
+	if err != nil {
+		log.Error("Image garbage collection failed! CAUSE --> ", err)
+		report.Error = err.Error()
+	}
+
+	imageGCReportLock.Lock()
+	imageGCReport = report
+	imageGCReportLock.Unlock()
+}
+
+func collectImageGarbage(report *com.ImageGCMsg) error {
+	diskStat, err := disk.Usage("/")
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	report.DiskUsage = diskStat.UsedPercent
+
+	if diskStat.UsedPercent < config.Params.ImageGCHigh {
+		return nil
+	}
+	log.Infof("Disk usage %.1f%% is above %.1f%%, removing unused images ...", diskStat.UsedPercent, config.Params.ImageGCHigh)
+
+	candidates, err := getUnusedImages()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	for _, image := range candidates {
+		if report.DiskUsage < config.Params.ImageGCLow {
+			break
+		}
+
+		log.Info("Removing unused image ", image.ID, " ", image.RepoTags)
+		err := docker.ImageRemoveAllTags(image.ID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		report.RemovedImages = append(report.RemovedImages, image.ID)
+		report.ReclaimedSpace += image.Size
+
+		diskStat, err := disk.Usage("/")
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		report.DiskUsage = diskStat.UsedPercent
+	}
+
+	log.Infof("Removed %v unused images, disk usage is %.1f%%", len(report.RemovedImages), report.DiskUsage)
+	return nil
+}
+
+// getUnusedImages returns the images that can be removed, oldest first
+func getUnusedImages() ([]types.ImageSummary, error) {
+	usedImageIDs, err := getUsedImageIDs()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	images, err := docker.ReadAllImages()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	// newest first, so that the versions to keep come first within each repository
+	sort.Slice(images, func(i, j int) bool { return images[i].Created > images[j].Created })
+
+	var unused []types.ImageSummary
+	versions := make(map[string]int)
+	for _, image := range images {
+		if usedImageIDs[image.ID] {
+			continue
+		}
+
+		repository := imageRepository(image)
+		if repository != "" {
+			versions[repository]++
+			if versions[repository] <= config.Params.ImageGCKeep {
+				continue
+			}
+		}
+
+		unused = append(unused, image)
+	}
+
+	sort.Slice(unused, func(i, j int) bool { return unused[i].Created < unused[j].Created })
+	return unused, nil
+}
+
+// getUsedImageIDs returns the IDs of the images used by containers, known manifests, their deployment history and staged manifests
+func getUsedImageIDs() (map[string]bool, error) {
+	used := make(map[string]bool)
+
+	containers, err := docker.ReadAllContainers()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, container := range containers {
+		used[container.ImageID] = true
+	}
+
+	imageNames := manifest.GetStagedImages()
+	for uniqueID, record := range manifest.GetKnownManifests() {
+		for _, module := range record.Manifest.Modules {
+			imageNames = append(imageNames, module.ImageNameFull)
+		}
+		imageNames = append(imageNames, manifest.GetHistoryImages(uniqueID)...)
+	}
+
+	for _, imageName := range imageNames {
+		imageID, err := docker.GetImageID(imageName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect image %v: %w", imageName, err)
+		}
+		if imageID != "" {
+			used[imageID] = true
+		}
+	}
+
+	return used, nil
+}
+
+// imageRepository returns the repository of the image or an empty string for dangling images
+func imageRepository(image types.ImageSummary) string {
+	var refs []string
+	refs = append(refs, image.RepoTags...)
+	refs = append(refs, image.RepoDigests...)
+	for _, ref := range refs {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err == nil {
+			return reference.FamiliarName(named)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package edgeapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const checksumFileSuffix = ".sha256"
+
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.xz"}
+
+// importLock serializes the imports, importedArchives remembers the archives that were already imported
+var importLock sync.Mutex
+var importedArchives = make(map[string]time.Time)
+
+// ImportImageArchives imports the new archives in the image import directory.
+// Every archive needs a checksum file <archive>.sha256 next to it, as written by sha256sum,
+// archives without a checksum file or with a wrong checksum are not imported.
+func ImportImageArchives() {
+	if config.Params.ImageImportDir == "" {
+		return
+	}
+
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	entries, err := os.ReadDir(config.Params.ImageImportDir)
+	if err != nil {
+		log.Error("Reading the image import directory failed! CAUSE --> ", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isImageArchive(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			log.Error("Reading image archive info failed! CAUSE --> ", err)
+			continue
+		}
+
+		path := filepath.Join(config.Params.ImageImportDir, entry.Name())
+		if importedArchives[path].Equal(info.ModTime()) {
+			continue
+		}
+
+		checksum, err := readChecksumFile(path + checksumFileSuffix)
+		if err != nil {
+			log.Warnf("Skipping image archive %v without valid checksum file. CAUSE --> %v", path, err)
+			continue
+		}
+
+		err = importImageArchive(path, checksum)
+		if err != nil {
+			log.Errorf("Importing image archive %v failed! CAUSE --> %v", path, err)
+		}
+		// archives are not retried until they change, a wrong archive would otherwise be checked again and again
+		importedArchives[path] = info.ModTime()
+	}
+}
+
+// importMissingImages imports the missing images from the archives referenced by the modules
+// or found in the image import directory, and returns the modules whose images are still missing
+func importMissingImages(deploymentID string, missing []manifest.ContainerConfig) []manifest.ContainerConfig {
+	ImportImageArchives()
+
+	var stillMissing []manifest.ContainerConfig
+	for _, module := range missing {
+		if module.ImageArchive != "" {
+			importLock.Lock()
+			err := importImageArchive(module.ImageArchive, module.ImageArchiveSha256)
+			importLock.Unlock()
+			if err != nil {
+				log.Error(deploymentID, fmt.Sprintf("Importing image %v from %v failed! CAUSE --> %v", module.ImageNameFull, module.ImageArchive, err))
+			}
+		}
+
+		// an image pinned by a digest is never found after an import, because docker save does not keep the repository digest
+		exists, err := docker.ImageExists(module.ImageNameFull)
+		if err != nil || !exists {
+			stillMissing = append(stillMissing, module)
+			continue
+		}
+		log.Info(deploymentID, fmt.Sprintf("Image %v imported from archive", module.ImageNameFull))
+	}
+
+	return stillMissing
+}
+
+// importImageArchive verifies the SHA-256 checksum of the archive and loads its images
+func importImageArchive(path string, checksum string) error {
+	actual, err := fileSha256(path)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if actual != checksum {
+		return fmt.Errorf("checksum of image archive %v is %v, expected %v", path, actual, checksum)
+	}
+
+	archive, err := os.Open(path)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	defer archive.Close()
+
+	log.Info("Importing image archive ", path)
+	loaded, err := docker.LoadImage(archive)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	log.Infof("Imported images %v from archive %v", loaded, path)
+
+	return nil
+}
+
+func isImageArchive(name string) bool {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readChecksumFile returns the hex encoded SHA-256 checksum from a file in the format of sha256sum
+func readChecksumFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum file %v", path)
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", fmt.Errorf("invalid checksum file %v", path)
+	}
+
+	return strings.ToLower(fields[0]), nil
+}
+
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
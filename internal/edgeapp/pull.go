@@ -0,0 +1,192 @@
+package edgeapp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+	"github.com/beetaone/beeta-agent/internal/registry"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const (
+	pullProgressInterval = 2 * time.Second
+	maxPullBackoff       = 5 * time.Minute
+)
+
+// pullImages imports or pulls the missing images of the edge app, up to PullParallel images are pulled at the same time
+func pullImages(man manifest.Manifest) error {
+	deploymentID := man.UniqueID.String() + " | "
+
+	var missing []manifest.ContainerConfig
+	checked := make(map[string]bool)
+	for _, module := range man.Modules {
+		if checked[module.ImageNameFull] {
+			continue
+		}
+		checked[module.ImageNameFull] = true
+
+		// Check if image exist in local
+		exists, err := docker.ImageExists(module.ImageNameFull)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if exists {
+			log.Info(deploymentID, fmt.Sprintf("Image %v, already exists on host", module.ImageNameFull))
+		} else {
+			log.Info(deploymentID, fmt.Sprintf("Image %v, does not exist on host", module.ImageNameFull))
+			missing = append(missing, module)
+		}
+	}
+
+	// images available offline are imported first, only the remaining ones are pulled from the registries
+	if len(missing) > 0 {
+		missing = importMissingImages(deploymentID, missing)
+	}
+
+	limiter := docker.NewBandwidthLimiter(config.Params.PullMaxRate)
+	modules := make(chan manifest.ContainerConfig)
+	errs := make(chan error, len(missing))
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Params.PullParallel && i < len(missing); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for module := range modules {
+				log.Info(deploymentID, "Pulling ", module.ImageNameFull)
+				err := pullImage(man.UniqueID, module, limiter)
+				if err != nil {
+					errs <- fmt.Errorf("%v: %w", module.ImageNameFull, err)
+				}
+			}
+		}()
+	}
+
+	for _, module := range missing {
+		// don't start new pulls once a pull failed, the deployment is going to be rolled back anyway
+		if len(errs) > 0 {
+			break
+		}
+		modules <- module
+	}
+	close(modules)
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// pullImage pulls the image of the module, publishing the progress to the manager
+// and retrying with an exponential backoff when the pull fails for a transient reason
+func pullImage(manifestUniqueID model.ManifestUniqueID, module manifest.ContainerConfig, limiter *docker.BandwidthLimiter) error {
+	authConfig, err := registry.ResolveAuthConfig(module.RegistryCredential, module.ImageNameFull, module.AuthConfig)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	backoff := time.Second * time.Duration(config.Params.PullBackoff)
+
+	for attempt := 1; ; attempt++ {
+		reportProgress := newPullProgressReporter(manifestUniqueID, module.ImageNameFull, attempt)
+
+		err := docker.PullImage(authConfig, module.ImageNameFull, limiter, reportProgress)
+		if err == nil {
+			return nil
+		}
+
+		if attempt > config.Params.PullRetries || !isTransientPullError(err) {
+			return traceutility.Wrap(err)
+		}
+
+		log.Warnf("Pulling image %v failed (attempt %v), retrying in %v. CAUSE --> %v", module.ImageNameFull, attempt, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxPullBackoff {
+			backoff = maxPullBackoff
+		}
+	}
+}
+
+// permanentPullMessages are parts of the errors the docker daemon reports in the pull stream
+// when the image does not exist or the registry refused the credentials
+var permanentPullMessages = []string{
+	"manifest unknown",
+	"not found",
+	"does not exist",
+	"unauthorized",
+	"authentication required",
+	"denied",
+	"forbidden",
+	"invalid reference format",
+}
+
+// isTransientPullError reports if retrying the pull can succeed, e.g. not after the registry refused the credentials
+func isTransientPullError(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if errdefs.IsNotFound(err) || errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) || errdefs.IsInvalidParameter(err) {
+			return false
+		}
+
+		// errors in the pull stream carry the HTTP status of the registry at most
+		if streamErr, ok := err.(*jsonmessage.JSONError); ok {
+			if streamErr.Code == http.StatusNotFound || streamErr.Code == http.StatusUnauthorized || streamErr.Code == http.StatusForbidden {
+				return false
+			}
+			message := strings.ToLower(streamErr.Message)
+			for _, permanent := range permanentPullMessages {
+				if strings.Contains(message, permanent) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// newPullProgressReporter returns a callback that publishes the pull progress of the image at most every pullProgressInterval
+func newPullProgressReporter(manifestUniqueID model.ManifestUniqueID, imageName string, attempt int) func(docker.PullProgress) {
+	start := time.Now()
+	var lastSent time.Time
+
+	return func(progress docker.PullProgress) {
+		now := time.Now()
+		if now.Sub(lastSent) < pullProgressInterval && progress.Current < progress.Total {
+			return
+		}
+		lastSent = now
+
+		msg := com.PullProgressMsg{
+			ManifestID: manifestUniqueID.String(),
+			Image:      imageName,
+			Attempt:    attempt,
+			Downloaded: progress.Current,
+			Total:      progress.Total,
+			ETA:        -1,
+		}
+
+		elapsed := now.Sub(start).Seconds()
+		if progress.Current > 0 && elapsed > 0 {
+			rate := float64(progress.Current) / elapsed
+			msg.ETA = int64(float64(progress.Total-progress.Current) / rate)
+		}
+
+		err := com.SendPullProgress(msg)
+		if err != nil {
+			log.Error("SendPullProgress failed! CAUSE --> ", err)
+		}
+	}
+}
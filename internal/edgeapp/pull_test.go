@@ -0,0 +1,39 @@
+package edgeapp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/stretchr/testify/assert"
+
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+func TestIsTransientPullError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"connection reset", errors.New("read tcp 10.0.0.1:443: connection reset by peer"), true},
+		{"daemon not found", errdefs.NotFound(errors.New("no such image")), false},
+		{"daemon unauthorized", errdefs.Unauthorized(errors.New("unauthorized")), false},
+		{"wrapped daemon error", traceutility.Wrap(fmt.Errorf("pull: %w", errdefs.Forbidden(errors.New("forbidden")))), false},
+		{"stream manifest unknown", &jsonmessage.JSONError{Message: "manifest for app:1 not found: manifest unknown: manifest unknown"}, false},
+		{"stream repository does not exist", &jsonmessage.JSONError{Message: "pull access denied for app, repository does not exist or may require 'docker login'"}, false},
+		{"stream unauthorized", &jsonmessage.JSONError{Message: "unauthorized: authentication required"}, false},
+		{"stream status code", &jsonmessage.JSONError{Code: 404, Message: "error"}, false},
+		{"stream timeout", &jsonmessage.JSONError{Message: "Get https://registry/v2/: net/http: TLS handshake timeout"}, true},
+		{"stream rate limit", &jsonmessage.JSONError{Message: "toomanyrequests: You have reached your pull rate limit"}, true},
+		{"wrapped stream error", traceutility.Wrap(&jsonmessage.JSONError{Message: "manifest unknown"}), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.transient, isTransientPullError(test.err))
+		})
+	}
+}
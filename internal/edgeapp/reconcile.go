@@ -0,0 +1,302 @@
+package edgeapp
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const (
+	OrphanPolicyReport  = "report"
+	OrphanPolicyAdopt   = "adopt"
+	OrphanPolicyCleanup = "cleanup"
+)
+
+// orphans are the edge apps whose containers or networks carry a manifestUniqueID label, but which are not known,
+// they are reported to the manager until it adopts or cleans them up
+var orphans = make(map[model.ManifestUniqueID]com.OrphanedEdgeAppMsg)
+var orphansLock sync.Mutex
+
+// labelledResources are the docker resources of an edge app found by the manifestUniqueID label
+type labelledResources struct {
+	containers []types.Container
+	networks   []types.NetworkResource
+}
+
+// ReconcileEdgeApps compares the edge apps known to the agent with the labelled containers and networks on startup.
+// If the known manifests file was lost, all edge apps are adopted. Otherwise unknown edge apps are handled
+// according to OrphanPolicy: reported to the manager (default), adopted or cleaned up.
+func ReconcileEdgeApps() error {
+	resources, err := readLabelledResources()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	policy := config.Params.OrphanPolicy
+	if manifest.KnownManifestsLost() {
+		log.Warn("Rebuilding the known manifests from the edge app containers ...")
+		policy = OrphanPolicyAdopt
+	}
+
+	for uniqueID, res := range resources {
+		if manifest.GetKnownManifest(uniqueID) != nil {
+			continue
+		}
+
+		switch {
+		case policy == OrphanPolicyAdopt && len(res.containers) > 0:
+			err = adoptEdgeApp(uniqueID, res)
+		case policy == OrphanPolicyCleanup:
+			err = cleanupEdgeApp(uniqueID, res)
+		default:
+			log.Warnf("Found orphaned edge app %v with %v containers and %v networks", uniqueID, len(res.containers), len(res.networks))
+			addOrphan(uniqueID, res)
+			continue
+		}
+		if err != nil {
+			log.Errorf("Failed to reconcile orphaned edge app %v! CAUSE --> %v", uniqueID, err)
+			addOrphan(uniqueID, res)
+		}
+	}
+
+	return nil
+}
+
+// AdoptEdgeApp adds an orphaned edge app to the known edge apps
+func AdoptEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
+	res, err := readOrphanResources(manifestUniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if len(res.containers) == 0 {
+		return errors.New("orphaned edge app " + manifestUniqueID.String() + " has no containers to adopt, clean it up instead")
+	}
+
+	err = adoptEdgeApp(manifestUniqueID, res)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return SendStatus()
+}
+
+// CleanupEdgeApp removes the containers and networks of an orphaned edge app, its volumes are kept
+func CleanupEdgeApp(manifestUniqueID model.ManifestUniqueID) error {
+	res, err := readOrphanResources(manifestUniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = cleanupEdgeApp(manifestUniqueID, res)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return SendStatus()
+}
+
+// getOrphanedEdgeApps returns the orphaned edge apps for the status message
+func getOrphanedEdgeApps() []com.OrphanedEdgeAppMsg {
+	orphansLock.Lock()
+	defer orphansLock.Unlock()
+
+	orphanedEdgeApps := []com.OrphanedEdgeAppMsg{}
+	for _, orphan := range orphans {
+		orphanedEdgeApps = append(orphanedEdgeApps, orphan)
+	}
+	return orphanedEdgeApps
+}
+
+func addOrphan(uniqueID model.ManifestUniqueID, res *labelledResources) {
+	orphan := com.OrphanedEdgeAppMsg{ManifestID: uniqueID.ID, Containers: []string{}, Networks: []string{}}
+	for _, container := range res.containers {
+		orphan.Containers = append(orphan.Containers, containerName(container))
+	}
+	for _, network := range res.networks {
+		orphan.Networks = append(orphan.Networks, network.Name)
+	}
+
+	orphansLock.Lock()
+	defer orphansLock.Unlock()
+	orphans[uniqueID] = orphan
+}
+
+func removeOrphan(uniqueID model.ManifestUniqueID) {
+	orphansLock.Lock()
+	defer orphansLock.Unlock()
+	delete(orphans, uniqueID)
+}
+
+// readOrphanResources reads the current docker resources of an edge app that is not known
+func readOrphanResources(manifestUniqueID model.ManifestUniqueID) (*labelledResources, error) {
+	if manifest.GetKnownManifest(manifestUniqueID) != nil {
+		return nil, errors.New("edge app " + manifestUniqueID.String() + " is known, it is not orphaned")
+	}
+
+	containers, err := docker.ReadEdgeAppContainers(manifestUniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	networks, err := docker.ReadEdgeAppNetworks(manifestUniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	if len(containers) == 0 && len(networks) == 0 {
+		removeOrphan(manifestUniqueID)
+		return nil, errors.New("edge app " + manifestUniqueID.String() + " has no containers or networks")
+	}
+
+	return &labelledResources{containers: containers, networks: networks}, nil
+}
+
+// readLabelledResources groups the containers and networks carrying a manifestUniqueID label by edge app
+func readLabelledResources() (map[model.ManifestUniqueID]*labelledResources, error) {
+	resources := make(map[model.ManifestUniqueID]*labelledResources)
+	get := func(uniqueID string) *labelledResources {
+		id := model.ManifestUniqueID{ID: uniqueID}
+		if resources[id] == nil {
+			resources[id] = &labelledResources{}
+		}
+		return resources[id]
+	}
+
+	containers, err := docker.ReadAllContainers()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, container := range containers {
+		if uniqueID, ok := container.Labels["manifestUniqueID"]; ok {
+			res := get(uniqueID)
+			res.containers = append(res.containers, container)
+		}
+	}
+
+	networks, err := docker.ReadAllEdgeAppNetworks()
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	for _, network := range networks {
+		if uniqueID, ok := network.Labels["manifestUniqueID"]; ok {
+			res := get(uniqueID)
+			res.networks = append(res.networks, network)
+		}
+	}
+
+	return resources, nil
+}
+
+// adoptEdgeApp recreates the known manifest of the edge app from its containers. The rebuilt manifest only holds
+// what the containers tell, which is enough to stop, resume, undeploy and remove the edge app,
+// the manager replaces it when it deploys the edge app again.
+func adoptEdgeApp(uniqueID model.ManifestUniqueID, res *labelledResources) error {
+	man := rebuildManifest(uniqueID, res.containers)
+
+	status := model.EdgeAppStopped
+	for _, container := range res.containers {
+		if container.State == strings.ToLower(model.ModuleRunning) {
+			status = model.EdgeAppRunning
+		}
+	}
+
+	err := manifest.AddRecoveredManifest(man, status)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	removeOrphan(uniqueID)
+
+	if man.UpdatedAt.IsZero() {
+		log.Warnf("Adopted edge app %v with %v modules, status %v, its version is not known", uniqueID, len(man.Modules), status)
+	} else {
+		log.Infof("Adopted edge app %v version %v with %v modules, status %v", uniqueID, man.UpdatedAt.Format(time.RFC3339), len(man.Modules), status)
+	}
+	return nil
+}
+
+// cleanupEdgeApp removes the containers and networks of the edge app, the volumes are kept so that no data is lost
+func cleanupEdgeApp(uniqueID model.ManifestUniqueID, res *labelledResources) error {
+	for _, container := range res.containers {
+		err := docker.StopAndRemoveContainer(container.ID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+	}
+
+	if len(res.networks) > 0 {
+		err := docker.NetworkPrune(uniqueID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+	}
+
+	err := removeSecretFiles(uniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	removeOrphan(uniqueID)
+
+	log.Infof("Cleaned up orphaned edge app %v, removed %v containers and %v networks", uniqueID, len(res.containers), len(res.networks))
+	return nil
+}
+
+// rebuildManifest recreates the manifest from the containers of the edge app. The manifest name and version are taken
+// from the container labels, containers deployed by older agents do not carry them and the version stays zero.
+func rebuildManifest(uniqueID model.ManifestUniqueID, containers []types.Container) manifest.Manifest {
+	labels := map[string]string{"manifestUniqueID": uniqueID.String()}
+	man := manifest.Manifest{
+		UniqueID: uniqueID,
+		ID:       uniqueID.ID,
+		Labels:   labels,
+	}
+
+	// the container names end with the index of the module
+	sort.Slice(containers, func(i, j int) bool { return moduleIndex(containers[i]) < moduleIndex(containers[j]) })
+
+	for _, container := range containers {
+		if man.ManifestName == "" {
+			man.ManifestName = container.Labels["manifestName"]
+		}
+		if updatedAt, err := time.Parse(time.RFC3339, container.Labels["updatedAt"]); err == nil && man.UpdatedAt.IsZero() {
+			man.UpdatedAt = updatedAt
+		}
+
+		module := manifest.ContainerConfig{
+			ContainerName: containerName(container),
+			ImageNameFull: container.Image,
+			Labels:        labels,
+		}
+		if container.NetworkSettings != nil {
+			for networkName := range container.NetworkSettings.Networks {
+				module.NetworkName = networkName
+			}
+		}
+		man.Modules = append(man.Modules, module)
+	}
+
+	return man
+}
+
+func moduleIndex(container types.Container) int {
+	name := containerName(container)
+	index, _ := strconv.Atoi(name[strings.LastIndex(name, ".")+1:])
+	return index
+}
+
+func containerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return container.ID
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
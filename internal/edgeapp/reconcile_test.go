@@ -0,0 +1,49 @@
+package edgeapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/model"
+)
+
+func TestRebuildManifest(t *testing.T) {
+	uniqueID := model.ManifestUniqueID{ID: "app"}
+	updatedAt, _ := time.Parse(time.RFC3339, "2024-05-01T10:00:00Z")
+
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		manifestName string
+		updatedAt    time.Time
+	}{
+		{
+			"version labels",
+			map[string]string{"manifestUniqueID": "app", "manifestName": "demo", "updatedAt": "2024-05-01T10:00:00Z"},
+			"demo",
+			updatedAt,
+		},
+		{"deployed by an older agent", map[string]string{"manifestUniqueID": "app"}, "", time.Time{}},
+		{"invalid version", map[string]string{"manifestUniqueID": "app", "updatedAt": "yesterday"}, "", time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containers := []types.Container{
+				{Names: []string{"/demo.app.1"}, Image: "module-b", Labels: test.labels},
+				{Names: []string{"/demo.app.0"}, Image: "module-a", Labels: test.labels},
+			}
+
+			man := rebuildManifest(uniqueID, containers)
+			assert.Equal(t, test.manifestName, man.ManifestName)
+			assert.Equal(t, test.updatedAt, man.UpdatedAt)
+			if assert.Len(t, man.Modules, 2) {
+				assert.Equal(t, "module-a", man.Modules[0].ImageNameFull)
+				assert.Equal(t, "module-b", man.Modules[1].ImageNameFull)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package edgeapp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// RollbackEdgeApp redeploys a version of the edge app from its deployment history, the version with the given updatedAt
+// or, if it is zero, the newest version older than the deployed one. The images of the version must still be on the node,
+// they are not pulled again. The data volumes are kept. The version is checked before the deployed one is removed,
+// and if its deployment fails anyway, the previously deployed version is redeployed.
+func RollbackEdgeApp(manifestUniqueID model.ManifestUniqueID, updatedAt time.Time) error {
+	rollbackID := manifestUniqueID.String() + " | "
+
+	log.Info(rollbackID, "Rolling back edge app ...")
+
+	record := manifest.GetKnownManifest(manifestUniqueID)
+	if record == nil {
+		return errors.New("edge app " + manifestUniqueID.String() + " is not known")
+	}
+	if record.Rebuilt && record.Manifest.UpdatedAt.IsZero() && updatedAt.IsZero() {
+		return errors.New("the deployed version of edge app " + manifestUniqueID.String() + " is not known, its manifest was rebuilt from the containers")
+	}
+	previous := *record
+
+	//******** STEP 1 - Select the version and check that it can be deployed *************//
+	versions, err := manifest.GetHistory(manifestUniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	version, err := selectRollbackVersion(versions, previous.Manifest.UpdatedAt, updatedAt)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	man, err := manifest.UnsealSecretValues(version)
+	if err != nil {
+		log.Error(rollbackID, "Rollback rejected! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+
+	var images []string
+	for _, module := range man.Modules {
+		exists, err := docker.ImageExists(module.ImageNameFull)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if !exists {
+			return fmt.Errorf("image %v of version %v is not on the node anymore", module.ImageNameFull, man.UpdatedAt.Format(time.RFC3339))
+		}
+		images = append(images, module.ImageNameFull)
+	}
+
+	err = verifyImages(man)
+	if err != nil {
+		log.Error(rollbackID, "Rollback rejected! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+
+	err = checkAdmission(man)
+	if err != nil {
+		log.Error(rollbackID, "Rollback rejected! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+
+	// the deployed version is redeployed if the rollback fails, which needs its secret values as well
+	var fallback *manifest.Manifest
+	if previous.Status != model.EdgeAppUndeployed && !previous.Rebuilt {
+		previousMan, err := manifest.UnsealSecretValues(previous.Manifest)
+		if err != nil {
+			log.Warn(rollbackID, "The deployed version cannot be redeployed if the rollback fails! CAUSE --> ", err)
+		} else {
+			fallback = &previousMan
+			for _, module := range previousMan.Modules {
+				images = append(images, module.ImageNameFull)
+			}
+		}
+	}
+
+	//******** STEP 2 - Replace the deployed version *************//
+	// the deployed version has to be removed first, a deployment never replaces a version by an older one
+	err = removeEdgeApp(manifestUniqueID, images, false)
+	if err != nil {
+		log.Error(rollbackID, "Rollback failed! CAUSE --> ", err)
+		return traceutility.Wrap(err)
+	}
+
+	err = DeployEdgeApp(man)
+	if err != nil {
+		log.Error(rollbackID, "Rollback failed! CAUSE --> ", err)
+		if fallback != nil {
+			log.Info(rollbackID, "Redeploying version ", fallback.UpdatedAt.Format(time.RFC3339), " ...")
+			if redeployErr := DeployEdgeApp(*fallback); redeployErr != nil {
+				log.Error(rollbackID, "Redeploying the previous version failed! CAUSE --> ", redeployErr)
+			}
+		}
+		return traceutility.Wrap(err)
+	}
+
+	log.Info(rollbackID, "Rolled back to version ", man.UpdatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// selectRollbackVersion returns the version with the given updatedAt or, if it is zero, the newest version older than the deployed one.
+// A version whose secret values were lost is refused.
+func selectRollbackVersion(versions []manifest.Manifest, deployed time.Time, updatedAt time.Time) (manifest.Manifest, error) {
+	var selected *manifest.Manifest
+	for i, version := range versions {
+		if updatedAt.IsZero() {
+			if version.UpdatedAt.Before(deployed) && (selected == nil || version.UpdatedAt.After(selected.UpdatedAt)) {
+				selected = &versions[i]
+			}
+		} else if version.UpdatedAt.Equal(updatedAt) {
+			selected = &versions[i]
+		}
+	}
+
+	if selected == nil {
+		if updatedAt.IsZero() {
+			return manifest.Manifest{}, errors.New("the deployment history has no version older than the deployed one")
+		}
+		return manifest.Manifest{}, fmt.Errorf("the deployment history has no version %v", updatedAt.Format(time.RFC3339))
+	}
+	// without its secret values the version would be deployed with a broken configuration
+	if selected.SecretsLost() {
+		return manifest.Manifest{}, fmt.Errorf("version %v: %w", selected.UpdatedAt.Format(time.RFC3339), manifest.ErrSecretsLost)
+	}
+	return *selected, nil
+}
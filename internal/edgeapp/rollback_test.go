@@ -0,0 +1,58 @@
+package edgeapp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/manifest"
+)
+
+func TestSelectRollbackVersion(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 5, 1, hour, 0, 0, 0, time.UTC)
+	}
+	version := func(hour int, secretsLost bool) manifest.Manifest {
+		return manifest.Manifest{UpdatedAt: at(hour), Modules: []manifest.ContainerConfig{{SecretsLost: secretsLost}}}
+	}
+	versions := []manifest.Manifest{version(8, false), version(9, true), version(10, false), version(11, false), version(12, false)}
+
+	tests := []struct {
+		name      string
+		versions  []manifest.Manifest
+		deployed  time.Time
+		updatedAt time.Time
+		expected  time.Time
+		err       string
+	}{
+		{"newest older version", versions, at(12), time.Time{}, at(11), ""},
+		{"older versions are not ordered", []manifest.Manifest{version(11, false), version(8, false), version(10, false)}, at(12), time.Time{}, at(11), ""},
+		{"deployed version is not the newest", versions, at(11), time.Time{}, at(10), ""},
+		{"given version", versions, at(12), at(8), at(8), ""},
+		{"given version newer than the deployed one", versions, at(10), at(12), at(12), ""},
+		{"no older version", versions, at(8), time.Time{}, time.Time{}, "no version older"},
+		{"empty history", nil, at(12), time.Time{}, time.Time{}, "no version older"},
+		{"unknown version", versions, at(12), at(7), time.Time{}, "has no version 2024-05-01T07:00:00Z"},
+		{"secrets lost", versions, at(12), at(9), time.Time{}, "version 2024-05-01T09:00:00Z"},
+		{"newest older version lost its secrets", versions, at(10), time.Time{}, time.Time{}, "version 2024-05-01T09:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := selectRollbackVersion(test.versions, test.deployed, test.updatedAt)
+			if test.err == "" {
+				assert.Nil(t, err)
+				assert.Equal(t, test.expected, selected.UpdatedAt)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+
+	_, err := selectRollbackVersion(versions, at(12), at(9))
+	assert.True(t, errors.Is(err, manifest.ErrSecretsLost))
+}
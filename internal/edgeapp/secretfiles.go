@@ -0,0 +1,74 @@
+package edgeapp
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// writeSecretFiles writes the values the modules receive as files to their secrets directories,
+// which are mounted read-only into the containers. SecretsDir is expected on a tmpfs, so the files never touch the disk.
+func writeSecretFiles(man manifest.Manifest) error {
+	for _, module := range man.Modules {
+		if len(module.SecretFiles) == 0 {
+			continue
+		}
+
+		// only root can reach the secrets directories on the host, the containers get them mounted
+		err := os.MkdirAll(config.Params.SecretsDir, 0700)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = os.Chmod(config.Params.SecretsDir, 0700)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+
+		err = os.RemoveAll(module.SecretsDir)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = os.MkdirAll(module.SecretsDir, 0755)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+
+		// readable by every user, the container may run as a non-root user
+		for name, value := range module.SecretFiles {
+			err := os.WriteFile(filepath.Join(module.SecretsDir, name), []byte(value), 0444)
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeSecretFiles wipes the secret files of all modules of the edge app
+func removeSecretFiles(manifestUniqueID model.ManifestUniqueID) error {
+	err := os.RemoveAll(filepath.Join(config.Params.SecretsDir, manifestUniqueID.String()))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+// restoreSecretFiles writes the secret files of the edge app again from its sealed known manifest
+func restoreSecretFiles(manifestUniqueID model.ManifestUniqueID) error {
+	record := manifest.GetKnownManifest(manifestUniqueID)
+	if record == nil {
+		return nil
+	}
+
+	man, err := manifest.UnsealSecretValues(record.Manifest)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return writeSecretFiles(man)
+}
@@ -9,9 +9,11 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 
 	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
 	"github.com/beetaone/beeta-agent/internal/docker"
 	"github.com/beetaone/beeta-agent/internal/manifest"
 	"github.com/beetaone/beeta-agent/internal/model"
+	"github.com/beetaone/beeta-agent/internal/registry"
 	"github.com/beetaone/beeta-agent/internal/secret"
 	ioutility "github.com/beetaone/beeta-agent/internal/utility/io"
 	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
@@ -47,11 +49,17 @@ func GetStatusMessage() (com.StatusMsg, error) {
 	}
 
 	msg := com.StatusMsg{
-		Status:           nodeStatus,
-		EdgeApplications: edgeApps,
-		DeviceParams:     deviceParams,
-		AgentVersion:     model.Version,
-		OrgKeyHash:       secret.OrgKeyHash,
+		Status:              nodeStatus,
+		EdgeApplications:    edgeApps,
+		StagedEdgeApps:      getStagedEdgeApps(),
+		DeviceParams:        deviceParams,
+		AgentVersion:        model.Version,
+		OrgKeyHash:          secret.GetOrgKeyHash(),
+		OrgKeyHashes:        secret.GetOrgKeyHashes(),
+		NodeKeyRotation:     secret.IsNodeKeyRotationPending(),
+		ImageGC:             getImageGCReport(),
+		RegistryCredentials: registry.GetCredentialStatus(),
+		OrphanedEdgeApps:    getOrphanedEdgeApps(),
 	}
 
 	return msg, nil
@@ -62,6 +70,9 @@ func GetEdgeAppStatus() ([]com.EdgeAppMsg, error) {
 
 	for _, manif := range manifest.GetKnownManifests() {
 		edgeApplication := com.EdgeAppMsg{ManifestID: manif.Manifest.ID, Status: manif.Status}
+		edgeApplication.History = manifest.GetHistoryVersions(manif.Manifest.UniqueID)
+		edgeApplication.SecretsLost = manif.Manifest.SecretsLost()
+		edgeApplication.Rebuilt = manif.Rebuilt
 
 		if manif.Status == model.EdgeAppUndeployed {
 			edgeApps = append(edgeApps, edgeApplication)
@@ -104,6 +115,18 @@ func GetEdgeAppStatus() ([]com.EdgeAppMsg, error) {
 	return edgeApps, nil
 }
 
+func getStagedEdgeApps() []com.StagedEdgeAppMsg {
+	stagedEdgeApps := []com.StagedEdgeAppMsg{}
+	for _, man := range manifest.GetStagedManifests() {
+		stagedEdgeApps = append(stagedEdgeApps, com.StagedEdgeAppMsg{
+			ManifestID: man.ID,
+			UpdatedAt:  man.UpdatedAt,
+			Status:     model.EdgeAppStaged,
+		})
+	}
+	return stagedEdgeApps
+}
+
 func CompareEdgeAppStatus(edgeApps []com.EdgeAppMsg) ([]com.EdgeAppMsg, bool, error) {
 	statusChange := false
 
@@ -129,6 +152,11 @@ func getDeviceParams() (com.DeviceParamsMsg, error) {
 		return com.DeviceParamsMsg{}, traceutility.Wrap(err)
 	}
 
+	cpuCount, err := cpu.Counts(true)
+	if err != nil {
+		return com.DeviceParamsMsg{}, traceutility.Wrap(err)
+	}
+
 	cpu, err := cpu.Percent(0, false)
 	if err != nil {
 		return com.DeviceParamsMsg{}, traceutility.Wrap(err)
@@ -145,10 +173,25 @@ func getDeviceParams() (com.DeviceParamsMsg, error) {
 	}
 
 	params := com.DeviceParamsMsg{
-		SystemUpTime: uptime,
-		SystemLoad:   cpu[0],
-		StorageFree:  100.0 - diskStat.UsedPercent,
-		RamFree:      float64(verMem.Available) / float64(verMem.Total) * 100.0,
+		SystemUpTime:         uptime,
+		SystemLoad:           cpu[0],
+		StorageFree:          100.0 - diskStat.UsedPercent,
+		RamFree:              float64(verMem.Available) / float64(verMem.Total) * 100.0,
+		CPUCapacity:          float64(cpuCount),
+		RamCapacity:          int64(verMem.Total),
+		StorageCapacity:      int64(diskStat.Total),
+		StorageFreeAvailable: int64(diskStat.Free),
+	}
+
+	// the capacity configured in the agent config replaces the one reported by the host
+	if config.Params.NodeCPUCapacity > 0 {
+		params.CPUCapacity = config.Params.NodeCPUCapacity
+	}
+	if config.Params.NodeMemoryCapacity > 0 {
+		params.RamCapacity = config.Params.NodeMemoryCapacity * mb
+	}
+	if config.Params.NodeDiskCapacity > 0 {
+		params.StorageCapacity = config.Params.NodeDiskCapacity * mb
 	}
 
 	return params, nil
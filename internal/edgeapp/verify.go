@@ -0,0 +1,102 @@
+package edgeapp
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/secret"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// simpleSigningPayload is the part of the cosign simple signing payload that identifies the signed image
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// verifyImages checks that the local images match the digests they are pinned to and that their signatures are valid
+func verifyImages(man manifest.Manifest) error {
+	var keys []crypto.PublicKey
+
+	for _, module := range man.Modules {
+		if module.ImageDigest == "" {
+			if config.Params.RequireSignedImages {
+				return fmt.Errorf("image %v is not pinned by a digest, but the node requires signed images", module.ImageNameFull)
+			}
+			continue
+		}
+
+		matches, err := docker.ImageHasDigest(module.ImageNameFull, module.ImageDigest)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if !matches {
+			return fmt.Errorf("image %v does not match the digest %v", module.ImageNameFull, module.ImageDigest)
+		}
+
+		if module.ImageSignature == "" {
+			if config.Params.RequireSignedImages {
+				return fmt.Errorf("image %v is not signed, but the node requires signed images", module.ImageNameFull)
+			}
+			continue
+		}
+
+		if len(config.Params.ImageVerifyKeys) == 0 {
+			log.Warnf("Image %v is signed, but no keys to verify the signature are configured", module.ImageNameFull)
+			continue
+		}
+
+		if keys == nil {
+			keys, err = secret.LoadPublicKeys(config.Params.ImageVerifyKeys)
+			if err != nil {
+				return traceutility.Wrap(err)
+			}
+		}
+
+		err = verifyImageSignature(module, keys)
+		if err != nil {
+			return fmt.Errorf("verification of the signature of image %v failed: %w", module.ImageNameFull, err)
+		}
+		log.Infof("Verified signature of image %v", module.ImageNameFull)
+	}
+
+	return nil
+}
+
+// verifyImageSignature verifies either the signature of the cosign payload, which has to name the pinned digest,
+// or if there is no payload, the signature of the digest itself
+func verifyImageSignature(module manifest.ContainerConfig, keys []crypto.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(module.ImageSignature)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	payload := []byte(module.ImageDigest)
+	if module.ImageSignaturePayload != "" {
+		payload, err = base64.StdEncoding.DecodeString(module.ImageSignaturePayload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+
+		var simpleSigning simpleSigningPayload
+		err = json.Unmarshal(payload, &simpleSigning)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		if simpleSigning.Critical.Image.DockerManifestDigest != module.ImageDigest {
+			return fmt.Errorf("signature payload is for digest %v", simpleSigning.Critical.Image.DockerManifestDigest)
+		}
+	}
+
+	return secret.VerifySignature(keys, payload, signature)
+}
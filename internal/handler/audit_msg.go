@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/audit"
+	"github.com/beetaone/beeta-agent/internal/docker"
+	"github.com/beetaone/beeta-agent/internal/manifest"
+)
+
+// auditOrchestrationMessage records the outcome of an orchestration message in the audit log.
+// The command and manifest ID are taken from the payload as far as it can be parsed.
+func auditOrchestrationMessage(topic string, payload []byte, outcome string, cause error, containers []audit.ContainerChange) {
+	entry := audit.Entry{
+		Topic:      topic,
+		Outcome:    outcome,
+		Containers: containers,
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+	if payload != nil {
+		entry.Command, _ = manifest.GetCommand(payload)
+		if manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload); err == nil {
+			entry.ManifestID = manifestUniqueID.ID
+		}
+	}
+
+	recordAudit(entry)
+}
+
+// auditCommandMessage records the outcome of a message whose command is given by its topic, e.g. an organization key
+func auditCommandMessage(topic string, command string, outcome string, cause error) {
+	entry := audit.Entry{
+		Topic:   topic,
+		Command: command,
+		Outcome: outcome,
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+
+	recordAudit(entry)
+}
+
+func recordAudit(entry audit.Entry) {
+	err := audit.Record(entry)
+	if err != nil {
+		log.Error("Failed to write audit log entry! CAUSE --> ", err)
+	}
+}
+
+// readContainerStates returns the state of each container of the edge app by container name
+func readContainerStates(payload []byte) map[string]string {
+	states := make(map[string]string)
+
+	manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
+	if err != nil {
+		return states
+	}
+
+	containers, err := docker.ReadEdgeAppContainers(manifestUniqueID)
+	if err != nil {
+		log.Warn("Failed to read edge app containers for the audit log! CAUSE --> ", err)
+		return states
+	}
+
+	for _, c := range containers {
+		states[containerName(c)] = c.State
+	}
+	return states
+}
+
+// changedContainers compares the container states before and after a command
+func changedContainers(before map[string]string, after map[string]string) []audit.ContainerChange {
+	var changes []audit.ContainerChange
+
+	for name, state := range before {
+		if after[name] != state {
+			changes = append(changes, audit.ContainerChange{Name: name, Before: state, After: after[name]})
+		}
+	}
+	for name, state := range after {
+		if _, existed := before[name]; !existed {
+			changes = append(changes, audit.ContainerChange{Name: name, After: state})
+		}
+	}
+
+	return changes
+}
+
+func containerName(c types.Container) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
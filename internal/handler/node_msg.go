@@ -4,23 +4,46 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/beetaone/beeta-agent/internal/audit"
 	"github.com/beetaone/beeta-agent/internal/edgeapp"
 	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
 )
 
+// command of the node delete topic in the audit log
+const commandDeleteNode = "DELETE_NODE"
+
 var NodeDeleteHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 	log.Debugln("Received message on topic:", msg.Topic(), "Payload:", string(msg.Payload()))
-	DeleteNode(model.NodeDeleted)
+
+	_, err := VerifyManagerMessage(msg.Payload(), msg.Topic())
+	if err != nil {
+		log.Error("Rejected node delete message! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandDeleteNode, audit.OutcomeRejected, err)
+		return
+	}
+
+	err = DeleteNode(model.NodeDeleted)
+	if err != nil {
+		auditCommandMessage(msg.Topic(), commandDeleteNode, audit.OutcomeFailure, err)
+		return
+	}
+
+	auditCommandMessage(msg.Topic(), commandDeleteNode, audit.OutcomeSuccess, nil)
 }
 
-func DeleteNode(nodeStatus string) {
+// DeleteNode removes all edge apps and reports the node status, the audit log is kept
+func DeleteNode(nodeStatus string) error {
 	log.Debug("Deleting node...")
 
 	err := edgeapp.RemoveAll()
 	if err != nil {
 		log.Error("Deletion of node failed! CAUSE --> ", err)
+		err = traceutility.Wrap(err)
 	}
 
 	edgeapp.SetNodeStatus(nodeStatus)
 	edgeapp.SendStatus()
+
+	return err
 }
@@ -6,6 +6,7 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/beetaone/beeta-agent/internal/audit"
 	"github.com/beetaone/beeta-agent/internal/edgeapp"
 	"github.com/beetaone/beeta-agent/internal/manifest"
 	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
@@ -14,10 +15,23 @@ import (
 var OrchestrationHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 	log.Debugln("Received message on topic:", msg.Topic(), "Payload:", string(msg.Payload()))
 
-	err := ProcessOrchestrationMessage(msg.Payload())
+	payload, err := VerifyManagerMessage(msg.Payload(), msg.Topic())
+	if err != nil {
+		log.Error("Rejected orchestration message! CAUSE --> ", err)
+		auditOrchestrationMessage(msg.Topic(), nil, audit.OutcomeRejected, err, nil)
+		return
+	}
+
+	before := readContainerStates(payload)
+	err = ProcessOrchestrationMessage(payload)
+	containers := changedContainers(before, readContainerStates(payload))
 	if err != nil {
 		log.Error("Failed to process orchestration message! CAUSE --> ", err)
+		auditOrchestrationMessage(msg.Topic(), payload, audit.OutcomeFailure, err, containers)
+		return
 	}
+
+	auditOrchestrationMessage(msg.Topic(), payload, audit.OutcomeSuccess, nil, containers)
 }
 
 func ProcessOrchestrationMessage(payload []byte) error {
@@ -39,6 +53,17 @@ func ProcessOrchestrationMessage(payload []byte) error {
 		}
 		log.Info("Deployment done!")
 
+	case edgeapp.CMDPrefetch:
+		manifest, err := manifest.Parse(payload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = edgeapp.PrefetchEdgeApp(manifest)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Prefetch done!")
+
 	case edgeapp.CMDStop:
 		manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
 		if err != nil {
@@ -83,6 +108,43 @@ func ProcessOrchestrationMessage(payload []byte) error {
 		}
 		log.Info("Full removal done!")
 
+	case edgeapp.CMDAdopt:
+		manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = edgeapp.AdoptEdgeApp(manifestUniqueID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Orphaned edge app adopted!")
+
+	case edgeapp.CMDCleanup:
+		manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = edgeapp.CleanupEdgeApp(manifestUniqueID)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Orphaned edge app cleaned up!")
+
+	case edgeapp.CMDRollback:
+		manifestUniqueID, err := manifest.GetEdgeAppUniqueID(payload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		updatedAt, err := manifest.GetRollbackVersion(payload)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		err = edgeapp.RollbackEdgeApp(manifestUniqueID, updatedAt)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		log.Info("Rollback done!")
+
 	default:
 		return errors.New("received message with unknown command")
 	}
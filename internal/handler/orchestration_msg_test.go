@@ -49,8 +49,13 @@ func TestProcessMessagePass(t *testing.T) {
 		Heartbeat: 60,
 		NodeId:    "1234567890",
 		NodeName:  "Test Node",
+		DataDir:   t.TempDir(), // keep the state files out of the source tree
 	}
 	config.Set(opt)
+	err := config.SetupDataDir(map[string][]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
 	com.ConnectNode(map[string]mqtt.MessageHandler{})
 
 	assert := assert.New(t)
@@ -4,14 +4,83 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/beetaone/beeta-agent/internal/audit"
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/registry"
 	"github.com/beetaone/beeta-agent/internal/secret"
 )
 
+// commands of the secrets topics in the audit log
+const (
+	commandOrgKey              = "ORG_KEY"
+	commandRegistryCredentials = "REGISTRY_CREDENTIALS"
+	commandRotateNodeKey       = "ROTATE_NODE_KEY"
+)
+
 var OrgPrivKeyHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
 	log.Debugln("Received message on topic:", msg.Topic(), "Payload:", string(msg.Payload()))
 
-	err := secret.ProcessOrgPrivKeyMessage(msg.Payload())
+	payload, err := VerifyManagerMessage(msg.Payload(), msg.Topic())
+	if err != nil {
+		log.Error("Rejected organization private key message! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandOrgKey, audit.OutcomeRejected, err)
+		return
+	}
+
+	err = secret.ProcessOrgPrivKeyMessage(payload)
 	if err != nil {
 		log.Error("Failed to process organization private key message! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandOrgKey, audit.OutcomeFailure, err)
+		return
+	}
+
+	auditCommandMessage(msg.Topic(), commandOrgKey, audit.OutcomeSuccess, nil)
+}
+
+var RegistryCredentialsHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
+	log.Debugln("Received message on topic:", msg.Topic())
+
+	payload, err := VerifyManagerMessage(msg.Payload(), msg.Topic())
+	if err != nil {
+		log.Error("Rejected registry credentials message! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandRegistryCredentials, audit.OutcomeRejected, err)
+		return
+	}
+
+	err = registry.ProcessCredentialsMessage(payload)
+	if err != nil {
+		log.Error("Failed to process registry credentials message! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandRegistryCredentials, audit.OutcomeFailure, err)
+		return
 	}
+
+	auditCommandMessage(msg.Topic(), commandRegistryCredentials, audit.OutcomeSuccess, nil)
+}
+
+var NodeKeyRotationHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
+	log.Debugln("Received message on topic:", msg.Topic(), "Payload:", string(msg.Payload()))
+
+	// a rotation makes the node wait for the organization key encrypted with the new key, so it is never triggered unsigned
+	_, err := verifyManagerMessage(msg.Payload(), msg.Topic(), true)
+	if err != nil {
+		log.Error("Rejected node key rotation message! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandRotateNodeKey, audit.OutcomeRejected, err)
+		return
+	}
+
+	nodePubKey, err := secret.RotateNodeKey()
+	if err != nil {
+		log.Error("Failed to rotate node key! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandRotateNodeKey, audit.OutcomeFailure, err)
+		return
+	}
+
+	err = com.SendNodePublicKey(nodePubKey)
+	if err != nil {
+		log.Error("Sending node public key failed! CAUSE --> ", err)
+		auditCommandMessage(msg.Topic(), commandRotateNodeKey, audit.OutcomeFailure, err)
+		return
+	}
+
+	auditCommandMessage(msg.Topic(), commandRotateNodeKey, audit.OutcomeSuccess, nil)
 }
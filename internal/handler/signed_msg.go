@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/secret"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// signedMsg is the envelope of a message signed by the manager
+type signedMsg struct {
+	SignedPayload string `json:"signedPayload"` // base64 encoded signedPayload
+	Signature     string `json:"signature"`     // base64 encoded signature of the decoded signed payload
+}
+
+// signedPayload binds the message to the node, the topic and a point in time, so that it cannot be replayed
+type signedPayload struct {
+	NodeID    string          `json:"nodeId"`
+	Topic     string          `json:"topic"` // topic without the node ID, e.g. delete
+	Timestamp time.Time       `json:"timestamp"`
+	Nonce     string          `json:"nonce"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// seenNonces holds the nonces of the accepted messages with their timestamps until they are older than MessageMaxAge
+var seenNonces = make(map[string]time.Time)
+var seenNoncesLock sync.Mutex
+
+// startTime is the time the agent started, the nonces of older messages are lost with the restart
+var startTime = time.Now()
+
+// VerifyManagerMessage checks the signature of a message signed by the manager against the manager keys and returns
+// the message. The message must be signed for the topic it was received on.
+// Unsigned messages are returned unchanged unless signed messages are required, which they are as soon as
+// manager keys are configured.
+func VerifyManagerMessage(payload []byte, topic string) ([]byte, error) {
+	return verifyManagerMessage(payload, topic, config.Params.RequireSignedMessages || len(config.Params.ManagerVerifyKeys) > 0)
+}
+
+func verifyManagerMessage(payload []byte, topic string, requireSigned bool) ([]byte, error) {
+	var envelope signedMsg
+	err := json.Unmarshal(payload, &envelope)
+	if err != nil || envelope.SignedPayload == "" {
+		if requireSigned {
+			return nil, errors.New("message is not signed, but the node requires signed messages")
+		}
+		return payload, nil
+	}
+
+	if len(config.Params.ManagerVerifyKeys) == 0 {
+		return nil, errors.New("message is signed, but no keys to verify the signature are configured")
+	}
+
+	keys, err := secret.LoadPublicKeys(config.Params.ManagerVerifyKeys)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	signedBytes, err := base64.StdEncoding.DecodeString(envelope.SignedPayload)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	err = secret.VerifySignature(keys, signedBytes, signature)
+	if err != nil {
+		return nil, fmt.Errorf("verification of the signature of the message failed: %w", err)
+	}
+
+	var signed signedPayload
+	err = json.Unmarshal(signedBytes, &signed)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	if signed.NodeID != config.Params.NodeId {
+		return nil, fmt.Errorf("message is signed for node %v", signed.NodeID)
+	}
+	if config.Params.NodeId+"/"+signed.Topic != topic {
+		return nil, fmt.Errorf("message is signed for topic %v, but received on %v", signed.Topic, topic)
+	}
+	if signed.Nonce == "" {
+		return nil, errors.New("signed message has no nonce")
+	}
+	if len(signed.Message) == 0 {
+		return nil, errors.New("signed message has no message")
+	}
+
+	err = checkReplay(signed.Nonce, signed.Timestamp, time.Now())
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	log.Debugf("Verified signature of message %v", signed.Nonce)
+	return signed.Message, nil
+}
+
+// checkReplay rejects messages outside MessageMaxAge of the current time (in both directions to allow for clock skew)
+// and messages whose nonce was already accepted. Nonces are only held for MessageMaxAge, older messages are rejected anyway.
+// Messages from before the agent started are rejected as well, as their nonces are not known after a restart.
+func checkReplay(nonce string, timestamp time.Time, now time.Time) error {
+	maxAge := time.Second * time.Duration(config.Params.MessageMaxAge)
+
+	age := now.Sub(timestamp)
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("message timestamp %v is more than %v off the node time", timestamp, maxAge)
+	}
+	if timestamp.Before(startTime) {
+		return fmt.Errorf("message timestamp %v is before the agent start at %v", timestamp, startTime)
+	}
+
+	seenNoncesLock.Lock()
+	defer seenNoncesLock.Unlock()
+
+	for n, t := range seenNonces {
+		if now.Sub(t) > maxAge {
+			delete(seenNonces, n)
+		}
+	}
+
+	if _, seen := seenNonces[nonce]; seen {
+		return fmt.Errorf("message with nonce %v was already received", nonce)
+	}
+	seenNonces[nonce] = timestamp
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package handler_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/handler"
+)
+
+func TestVerifyManagerMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "manager.pem")
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Params.NodeId = "node-1"
+	config.Params.ManagerVerifyKeys = []string{keyPath}
+	config.Params.MessageMaxAge = 300
+	config.Params.RequireSignedMessages = true
+
+	message := `{"command":"STOP","manifestName":"app","versionNumber":1}`
+	topic := "node-1/orchestration/stop"
+	sign := func(nodeID string, topic string, timestamp time.Time, nonce string) []byte {
+		signed, err := json.Marshal(map[string]interface{}{
+			"nodeId":    nodeID,
+			"topic":     topic,
+			"timestamp": timestamp,
+			"nonce":     nonce,
+			"message":   json.RawMessage(message),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope, err := json.Marshal(map[string]string{
+			"signedPayload": base64.StdEncoding.EncodeToString(signed),
+			"signature":     base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, signed)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return envelope
+	}
+
+	payload, err := handler.VerifyManagerMessage(sign("node-1", "orchestration/stop", time.Now(), "nonce-1"), topic)
+	assert.Nil(err)
+	assert.JSONEq(message, string(payload))
+
+	// replayed message
+	_, err = handler.VerifyManagerMessage(sign("node-1", "orchestration/stop", time.Now(), "nonce-1"), topic)
+	assert.NotNil(err)
+
+	// stale message
+	_, err = handler.VerifyManagerMessage(sign("node-1", "orchestration/stop", time.Now().Add(-time.Hour), "nonce-2"), topic)
+	assert.NotNil(err)
+
+	// message signed for another topic
+	_, err = handler.VerifyManagerMessage(sign("node-1", "delete", time.Now(), "nonce-5"), topic)
+	assert.NotNil(err)
+	_, err = handler.VerifyManagerMessage(sign("node-1", "orchestration/stop", time.Now(), "nonce-6"), "node-1/delete")
+	assert.NotNil(err)
+
+	// message from before the agent started
+	_, err = handler.VerifyManagerMessage(sign("node-1", "orchestration/stop", time.Now().Add(-time.Minute), "nonce-7"), topic)
+	assert.NotNil(err)
+
+	// message for another node
+	_, err = handler.VerifyManagerMessage(sign("node-2", "orchestration/stop", time.Now(), "nonce-3"), topic)
+	assert.NotNil(err)
+
+	// tampered signature
+	var envelope map[string]string
+	if err := json.Unmarshal(sign("node-1", "orchestration/stop", time.Now(), "nonce-4"), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	envelope["signature"] = base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))
+	tampered, _ := json.Marshal(envelope)
+	_, err = handler.VerifyManagerMessage(tampered, topic)
+	assert.NotNil(err)
+
+	// unsigned message
+	_, err = handler.VerifyManagerMessage([]byte(message), topic)
+	assert.NotNil(err)
+
+	// unsigned message while manager keys are configured
+	config.Params.RequireSignedMessages = false
+	_, err = handler.VerifyManagerMessage([]byte(message), topic)
+	assert.NotNil(err)
+
+	config.Params.ManagerVerifyKeys = nil
+	payload, err = handler.VerifyManagerMessage([]byte(message), topic)
+	assert.Nil(err)
+	assert.Equal(message, string(payload))
+}
@@ -0,0 +1,343 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const BoltFile = "manifests.db"
+
+var (
+	metaBucket    = []byte("meta")
+	knownBucket   = []byte("known")
+	stagedBucket  = []byte("staged")
+	historyBucket = []byte("history") // a nested bucket per edge app, keyed by sequence number
+	schemaKey     = []byte("schemaVersion")
+)
+
+// boltStore keeps the manifests in an embedded key-value database, every record is updated on its own
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore() *boltStore {
+	return &boltStore{}
+}
+
+// Load opens the database. A corrupt database is moved aside, other errors, e.g. missing permissions, are returned
+// so that the manifests are not dropped for a problem that can be fixed. On the first start with the database,
+// the manifests are imported from the JSON files, which are kept as <file>.migrated.
+func (s *boltStore) Load() (map[model.ManifestUniqueID]*ManifestRecord, map[model.ManifestUniqueID]Manifest, bool, error) {
+	lost := false
+	path := config.StatePath(BoltFile)
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, nil, false, fmt.Errorf("%v is locked by another process: %w", path, err)
+	}
+	if err != nil {
+		if !isCorrupt(err) {
+			return nil, nil, false, traceutility.Wrap(err)
+		}
+
+		corruptFile := fmt.Sprintf("%v.corrupt-%v", path, time.Now().Unix())
+		log.Errorf("Failed to open %v, moving it to %v! CAUSE --> %v", path, corruptFile, err)
+		if renameErr := os.Rename(path, corruptFile); renameErr != nil {
+			return nil, nil, false, traceutility.Wrap(err)
+		}
+
+		db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, nil, false, traceutility.Wrap(err)
+		}
+		lost = true
+	}
+	s.db = db
+
+	created := false
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := meta.Get(schemaKey)
+		if version == nil {
+			created = true
+			return meta.Put(schemaKey, []byte(strconv.Itoa(schemaVersion)))
+		}
+		if v, err := strconv.Atoi(string(version)); err != nil || v > schemaVersion {
+			return fmt.Errorf("%v has schema version %s, this agent supports up to version %v", path, version, schemaVersion)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, false, traceutility.Wrap(err)
+	}
+
+	if created && !lost {
+		err = s.importFiles()
+		if err != nil {
+			return nil, nil, false, traceutility.Wrap(err)
+		}
+	}
+
+	known := make(map[model.ManifestUniqueID]*ManifestRecord)
+	staged := make(map[model.ManifestUniqueID]Manifest)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		err := forEach(tx, knownBucket, func(key []byte, value []byte) {
+			var record ManifestRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				log.Errorf("Failed to read known manifest %s! CAUSE --> %v", key, err)
+				lost = true
+				return
+			}
+			known[record.Manifest.UniqueID] = &record
+		})
+		if err != nil {
+			return err
+		}
+
+		return forEach(tx, stagedBucket, func(key []byte, value []byte) {
+			var man Manifest
+			if err := json.Unmarshal(value, &man); err != nil {
+				log.Errorf("Failed to read staged manifest %s! CAUSE --> %v", key, err)
+				return
+			}
+			staged[man.UniqueID] = man
+		})
+	})
+	if err != nil {
+		return nil, nil, false, traceutility.Wrap(err)
+	}
+
+	return known, staged, lost, nil
+}
+
+// isCorrupt tells if the database could not be opened because its file is not a valid database
+func isCorrupt(err error) bool {
+	return errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrChecksum) || errors.Is(err, bolt.ErrVersionMismatch)
+}
+
+// importFiles moves the manifests of the file store into the database
+func (s *boltStore) importFiles() error {
+	files := newFileStore()
+	known, staged, _, err := files.Load()
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	if len(known) == 0 && len(staged) == 0 && len(files.history) == 0 {
+		return nil
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for uniqueID, record := range known {
+			if err := put(tx, knownBucket, uniqueID, record); err != nil {
+				return err
+			}
+		}
+		for uniqueID, man := range staged {
+			if err := put(tx, stagedBucket, uniqueID, man); err != nil {
+				return err
+			}
+		}
+		for _, versions := range files.history {
+			for _, man := range versions {
+				if err := addHistory(tx, man, len(versions)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	for _, fileName := range []string{ManifestFile, StagedManifestFile, HistoryFile} {
+		path := config.StatePath(fileName)
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Rename(path, path+".migrated"); err != nil {
+				return traceutility.Wrap(err)
+			}
+		}
+	}
+	log.Infof("Imported %v known and %v staged manifests into %v", len(known), len(staged), BoltFile)
+
+	return nil
+}
+
+func (s *boltStore) PutKnown(record *ManifestRecord) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return put(tx, knownBucket, record.Manifest.UniqueID, record)
+	})
+}
+
+func (s *boltStore) DeleteKnown(manifestUniqueID model.ManifestUniqueID) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return del(tx, knownBucket, manifestUniqueID)
+	})
+}
+
+func (s *boltStore) PutStaged(man Manifest) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return put(tx, stagedBucket, man.UniqueID, man)
+	})
+}
+
+func (s *boltStore) DeleteStaged(manifestUniqueID model.ManifestUniqueID) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return del(tx, stagedBucket, manifestUniqueID)
+	})
+}
+
+func (s *boltStore) AddHistory(man Manifest, keep int) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return addHistory(tx, man, keep)
+	})
+}
+
+func (s *boltStore) GetHistory(manifestUniqueID model.ManifestUniqueID) ([]Manifest, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+
+	var versions []Manifest
+	err := s.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket)
+		if history == nil || history.Bucket([]byte(manifestUniqueID.ID)) == nil {
+			return nil
+		}
+
+		return history.Bucket([]byte(manifestUniqueID.ID)).ForEach(func(key []byte, value []byte) error {
+			var man Manifest
+			if err := json.Unmarshal(value, &man); err != nil {
+				return err
+			}
+			versions = append(versions, man)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return versions, nil
+}
+
+func (s *boltStore) DeleteHistory(manifestUniqueID model.ManifestUniqueID) error {
+	return s.update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket)
+		if history == nil || history.Bucket([]byte(manifestUniqueID.ID)) == nil {
+			return nil
+		}
+		return history.DeleteBucket([]byte(manifestUniqueID.ID))
+	})
+}
+
+func (s *boltStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.db == nil {
+		return fmt.Errorf("%v is not open", BoltFile)
+	}
+
+	err := s.db.Update(fn)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+func put(tx *bolt.Tx, bucketName []byte, manifestUniqueID model.ManifestUniqueID, v interface{}) error {
+	bucket, err := tx.CreateBucketIfNotExists(bucketName)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(manifestUniqueID.ID), value)
+}
+
+func del(tx *bolt.Tx, bucketName []byte, manifestUniqueID model.ManifestUniqueID) error {
+	bucket := tx.Bucket(bucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete([]byte(manifestUniqueID.ID))
+}
+
+func forEach(tx *bolt.Tx, bucketName []byte, fn func(key []byte, value []byte)) error {
+	bucket := tx.Bucket(bucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(key []byte, value []byte) error {
+		fn(key, value)
+		return nil
+	})
+}
+
+// addHistory appends the manifest to the history bucket of its edge app and drops the oldest versions beyond keep
+func addHistory(tx *bolt.Tx, man Manifest, keep int) error {
+	history, err := tx.CreateBucketIfNotExists(historyBucket)
+	if err != nil {
+		return err
+	}
+	versions, err := history.CreateBucketIfNotExists([]byte(man.UniqueID.ID))
+	if err != nil {
+		return err
+	}
+
+	seq, err := versions.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq) // big endian keys keep the versions in order
+
+	value, err := json.Marshal(man)
+	if err != nil {
+		return err
+	}
+	err = versions.Put(key, value)
+	if err != nil {
+		return err
+	}
+
+	var keys [][]byte
+	err = versions.ForEach(func(key []byte, value []byte) error {
+		keys = append(keys, append([]byte{}, key...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(keys)-keep; i++ {
+		err := versions.Delete(keys[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
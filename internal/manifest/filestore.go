@@ -0,0 +1,226 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/model"
+	ioutility "github.com/beetaone/beeta-agent/internal/utility/io"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const ManifestFile = "known_manifests.jsonl"
+const StagedManifestFile = "staged_manifests.jsonl"
+const HistoryFile = "manifest_history.jsonl"
+
+// fileStore keeps the known manifests, the staged manifests and the history in a JSON file each,
+// a file is written as a whole on every change
+type fileStore struct {
+	history map[model.ManifestUniqueID][]Manifest
+}
+
+func newFileStore() *fileStore {
+	return &fileStore{history: make(map[model.ManifestUniqueID][]Manifest)}
+}
+
+func (s *fileStore) Load() (map[model.ManifestUniqueID]*ManifestRecord, map[model.ManifestUniqueID]Manifest, bool, error) {
+	known := make(map[model.ManifestUniqueID]*ManifestRecord)
+	staged := make(map[model.ManifestUniqueID]Manifest)
+	lost := false
+
+	err := readFromFile(config.StatePath(ManifestFile), &known)
+	if errors.Is(err, errCorruptFile) {
+		known = make(map[model.ManifestUniqueID]*ManifestRecord)
+		lost = true
+	} else if err != nil {
+		return nil, nil, false, traceutility.Wrap(err)
+	}
+
+	// the staged manifests are only hints which images to keep, the manager prefetches them again if they are lost
+	err = readFromFile(config.StatePath(StagedManifestFile), &staged)
+	if errors.Is(err, errCorruptFile) {
+		staged = make(map[model.ManifestUniqueID]Manifest)
+	} else if err != nil {
+		return nil, nil, false, traceutility.Wrap(err)
+	}
+
+	history := make(map[model.ManifestUniqueID][]Manifest)
+	err = readFromFile(config.StatePath(HistoryFile), &history)
+	if errors.Is(err, errCorruptFile) {
+		history = make(map[model.ManifestUniqueID][]Manifest)
+	} else if err != nil {
+		return nil, nil, false, traceutility.Wrap(err)
+	}
+	s.history = history
+
+	return known, staged, lost, nil
+}
+
+// PutKnown writes all known manifests, the record has to be in the known manifests already
+func (s *fileStore) PutKnown(record *ManifestRecord) error {
+	return writeToFile(config.StatePath(ManifestFile), knownManifests)
+}
+
+func (s *fileStore) DeleteKnown(manifestUniqueID model.ManifestUniqueID) error {
+	return writeToFile(config.StatePath(ManifestFile), knownManifests)
+}
+
+// PutStaged writes all staged manifests, the manifest has to be in the staged manifests already
+func (s *fileStore) PutStaged(man Manifest) error {
+	return writeToFile(config.StatePath(StagedManifestFile), stagedManifests)
+}
+
+func (s *fileStore) DeleteStaged(manifestUniqueID model.ManifestUniqueID) error {
+	return writeToFile(config.StatePath(StagedManifestFile), stagedManifests)
+}
+
+func (s *fileStore) AddHistory(man Manifest, keep int) error {
+	previous := s.history[man.UniqueID]
+
+	versions := append(append([]Manifest{}, previous...), man)
+	if len(versions) > keep {
+		versions = versions[len(versions)-keep:]
+	}
+	if len(versions) == 0 {
+		delete(s.history, man.UniqueID)
+	} else {
+		s.history[man.UniqueID] = versions
+	}
+
+	err := writeToFile(config.StatePath(HistoryFile), s.history)
+	if err != nil {
+		s.history[man.UniqueID] = previous
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+func (s *fileStore) GetHistory(manifestUniqueID model.ManifestUniqueID) ([]Manifest, error) {
+	return append([]Manifest{}, s.history[manifestUniqueID]...), nil
+}
+
+func (s *fileStore) DeleteHistory(manifestUniqueID model.ManifestUniqueID) error {
+	previous, exists := s.history[manifestUniqueID]
+	if !exists {
+		return nil
+	}
+	delete(s.history, manifestUniqueID)
+
+	err := writeToFile(config.StatePath(HistoryFile), s.history)
+	if err != nil {
+		s.history[manifestUniqueID] = previous
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
+
+// schemaVersion is the version of the manifest files written by this agent
+const schemaVersion = 1
+
+// storeFile is the content of a manifest file, the data is kept raw so that it can be migrated before it is decoded
+type storeFile struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// migrations[i] migrates the data of a manifest file from schema version i to i+1
+var migrations = []func(data json.RawMessage) (json.RawMessage, error){
+	// version 0 files were written before the schema version was introduced and hold the bare map, which is unchanged
+	func(data json.RawMessage) (json.RawMessage, error) { return data, nil },
+}
+
+// errCorruptFile is returned by readFromFile if the file was corrupt and moved aside
+var errCorruptFile = errors.New("manifest file is corrupt")
+
+// readFromFile decodes the manifest file into v after migrating it to the current schema version.
+// A corrupt file, e.g. left by a crash of an older agent mid-write, is moved aside and errCorruptFile is returned.
+func readFromFile(fileName string, v interface{}) error {
+	// a left over temporary file is an interrupted write, the file itself is still intact
+	os.Remove(fileName + ".tmp")
+
+	content, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	version, data, err := decodeStoreFile(content)
+	if err == nil {
+		if version > schemaVersion {
+			return fmt.Errorf("%v has schema version %v, this agent supports up to version %v", fileName, version, schemaVersion)
+		}
+
+		for ; version < schemaVersion; version++ {
+			data, err = migrations[version](data)
+			if err != nil {
+				break
+			}
+			log.Infof("Migrated %v to schema version %v", fileName, version+1)
+		}
+	}
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err == nil {
+		return nil
+	}
+
+	corruptFile := fmt.Sprintf("%v.corrupt-%v", fileName, time.Now().Unix())
+	log.Errorf("Failed to read %v, moving it to %v! CAUSE --> %v", fileName, corruptFile, err)
+	err = os.Rename(fileName, corruptFile)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return errCorruptFile
+}
+
+// decodeStoreFile returns the schema version and the data of the file content
+func decodeStoreFile(content []byte) (int, json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(content, &fields)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, versioned := fields["schemaVersion"]; !versioned {
+		return 0, content, nil
+	}
+
+	var file storeFile
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return 0, nil, err
+	}
+	return file.SchemaVersion, file.Data, nil
+}
+
+// writeToFile replaces the manifest file atomically, so that a crash never leaves a partially written file
+func writeToFile(fileName string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	encodedJson, err := json.MarshalIndent(storeFile{SchemaVersion: schemaVersion, Data: data}, "", " ")
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	err = ioutility.WriteFileAtomic(fileName, encodedJson, 0644)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return nil
+}
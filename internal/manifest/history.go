@@ -0,0 +1,96 @@
+package manifest
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/model"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// AddHistory records the known manifest of the edge app in its deployment history, keeping the newest HistoryVersions versions.
+// The known manifest holds the version as it was received, with its secret values sealed.
+// A version that is in the history already, e.g. after a rollback to it, is not added again, nor is a rebuilt manifest.
+func AddHistory(manifestUniqueID model.ManifestUniqueID) error {
+	if config.Params.HistoryVersions <= 0 {
+		return nil
+	}
+
+	record := GetKnownManifest(manifestUniqueID)
+	if record == nil {
+		return errors.New("could not add the edge app to the history. the edge app " + manifestUniqueID.String() + " is not known")
+	}
+	if record.Rebuilt {
+		return nil
+	}
+
+	versions, err := store.GetHistory(manifestUniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	for _, version := range versions {
+		if version.UpdatedAt.Equal(record.Manifest.UpdatedAt) {
+			return nil
+		}
+	}
+
+	err = store.AddHistory(sealSecretValues(record.Manifest), config.Params.HistoryVersions)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
+
+// GetHistory returns the versions of the edge app in its deployment history, oldest first, with their secret values sealed
+func GetHistory(manifestUniqueID model.ManifestUniqueID) ([]Manifest, error) {
+	versions, err := store.GetHistory(manifestUniqueID)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	return versions, nil
+}
+
+// GetHistoryVersions returns the updatedAt of the versions in the deployment history of the edge app, oldest first
+func GetHistoryVersions(manifestUniqueID model.ManifestUniqueID) []time.Time {
+	versions, err := store.GetHistory(manifestUniqueID)
+	if err != nil {
+		log.Error("Failed to read the deployment history! CAUSE --> ", err)
+		return nil
+	}
+
+	var updatedAt []time.Time
+	for _, version := range versions {
+		updatedAt = append(updatedAt, version.UpdatedAt)
+	}
+	return updatedAt
+}
+
+// GetHistoryImages returns the images of all versions in the deployment history of the edge app,
+// they are kept on the node so that the edge app can be rolled back without pulling them again
+func GetHistoryImages(manifestUniqueID model.ManifestUniqueID) []string {
+	versions, err := store.GetHistory(manifestUniqueID)
+	if err != nil {
+		log.Error("Failed to read the deployment history! CAUSE --> ", err)
+		return nil
+	}
+
+	var images []string
+	for _, version := range versions {
+		for _, module := range version.Modules {
+			images = append(images, module.ImageNameFull)
+		}
+	}
+	return images
+}
+
+// DeleteHistory removes the deployment history of the edge app
+func DeleteHistory(manifestUniqueID model.ManifestUniqueID) error {
+	err := store.DeleteHistory(manifestUniqueID)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return nil
+}
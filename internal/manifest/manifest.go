@@ -2,7 +2,9 @@ package manifest
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -12,7 +14,9 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-playground/validator/v10/non-standard/validators"
 	log "github.com/sirupsen/logrus"
@@ -35,19 +39,52 @@ type Manifest struct {
 
 // This struct holds information for starting a container
 type ContainerConfig struct {
-	ContainerName string
-	ImageNameFull string
-	EnvArgs       []string
-	NetworkName   string
-	ExposedPorts  nat.PortSet // This must be set for the container create
-	PortBinding   nat.PortMap // This must be set for the containerStart
-	NetworkConfig network.NetworkingConfig
-	MountConfigs  []mount.Mount
-	Labels        map[string]string
-	AuthConfig    types.AuthConfig
-	Resources     container.Resources
+	ContainerName         string
+	ImageNameFull         string
+	ImageDigest           string
+	ImageSignature        string
+	ImageSignaturePayload string
+	ImageArchive          string // archive to import the image from before pulling it
+	ImageArchiveSha256    string
+	EnvArgs               []string
+	NetworkName           string
+	ExposedPorts          nat.PortSet // This must be set for the container create
+	PortBinding           nat.PortMap // This must be set for the containerStart
+	NetworkConfig         network.NetworkingConfig
+	MountConfigs          []mount.Mount
+	Labels                map[string]string
+	AuthConfig            types.AuthConfig
+	RegistryCredential    string
+	Resources             container.Resources
+	RestartPolicy         container.RestartPolicy
+	OomScoreAdj           int
+	DiskRequest           int64 // disk space in bytes the module asks to be reserved on the node
+	User                  string
+	Privileged            bool
+	CapAdd                strslice.StrSlice
+	CapDrop               strslice.StrSlice
+	SecurityOpt           []string
+	ReadonlyRoot          bool
+	SecretFiles           map[string]string // values delivered as files, by file name
+	SecretsDir            string            // host directory the secret files are written to
+	SealedSecrets         []byte            // env variables, secret files and registry password sealed with the node key, set in stored manifests only
+	SecretsLost           bool              // sealing the secret values failed, the stored manifest does not hold them
 }
 
+const (
+	mb              = 1024 * 1024
+	mountTypeVolume = "volume"
+	mountTypeTmpfs  = "tmpfs"
+
+	secretsMountPath = "/run/secrets"
+
+	defaultDevicePermissions = "rw"
+	unconfinedProfile        = "unconfined"
+	defaultCPUPeriod         = 100000
+	defaultRestartPolicy     = "on-failure"
+	defaultRestartRetries    = 100
+)
+
 type connectionsInt map[int][]int
 type connectionsString map[string][]string
 
@@ -85,7 +122,7 @@ func Parse(payload []byte) (Manifest, error) {
 
 	var containerConfigs []ContainerConfig
 
-	for _, module := range man.Modules {
+	for i, module := range man.Modules {
 		err = validate.Struct(module)
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
@@ -93,21 +130,44 @@ func Parse(payload []byte) (Manifest, error) {
 
 		var containerConfig ContainerConfig
 
-		containerConfig.Labels = labels
+		// the containers also carry the version of the manifest, so that it can be rebuilt from them if the known manifests are lost
+		containerConfig.Labels = map[string]string{
+			"manifestUniqueID": uniqueID.String(),
+			"manifestName":     man.ManifestName,
+			"updatedAt":        man.UpdatedAt,
+		}
 
 		if module.Image.Tag == "" {
 			containerConfig.ImageNameFull = module.Image.Name
 		} else {
 			containerConfig.ImageNameFull = module.Image.Name + ":" + module.Image.Tag
 		}
+		moduleName := containerConfig.ImageNameFull
+
+		// an image pinned by its digest is pulled and started by the digest, so a retagged image is never used
+		if module.Image.Digest != "" {
+			containerConfig.ImageNameFull += "@" + module.Image.Digest
+			containerConfig.ImageDigest = module.Image.Digest
+		}
+		containerConfig.ImageSignature = module.Image.Signature
+		containerConfig.ImageSignaturePayload = module.Image.SignaturePayload
+
+		if module.Image.Archive != "" {
+			containerConfig.ImageArchive = module.Image.Archive
+			if !filepath.IsAbs(containerConfig.ImageArchive) {
+				containerConfig.ImageArchive = filepath.Join(config.Params.ImageImportDir, containerConfig.ImageArchive)
+			}
+			containerConfig.ImageArchiveSha256 = strings.ToLower(module.Image.ArchiveSha256)
+		}
 
 		containerConfig.AuthConfig = types.AuthConfig{
 			ServerAddress: module.Image.Registry.Url,
 			Username:      module.Image.Registry.UserName,
 			Password:      module.Image.Registry.Password,
 		}
+		containerConfig.RegistryCredential = module.Image.Registry.CredentialName
 
-		envArgs, err := parseArguments(module.Envs)
+		envArgs, secretFiles, err := parseArguments(module.Envs)
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
 		}
@@ -119,7 +179,7 @@ func Parse(payload []byte) (Manifest, error) {
 		}
 
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MANIFEST_ID", man.ID))
-		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MODULE_NAME", containerConfig.ImageNameFull))
+		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MODULE_NAME", moduleName))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "INGRESS_PORT", 80))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "INGRESS_PATH", "/"))
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "MODULE_TYPE", module.Type))
@@ -127,7 +187,7 @@ func Parse(payload []byte) (Manifest, error) {
 		envArgs = append(envArgs, fmt.Sprintf("%v=%v", "NODE_NAME", config.Params.NodeName))
 
 		containerConfig.EnvArgs = envArgs
-		containerConfig.MountConfigs, err = parseMounts(module.Mounts)
+		containerConfig.MountConfigs, err = parseMounts(module.Mounts, uniqueID, labels)
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
 		}
@@ -136,7 +196,36 @@ func Parse(payload []byte) (Manifest, error) {
 		if err != nil {
 			return Manifest{}, traceutility.Wrap(err)
 		}
-		containerConfig.Resources = container.Resources{Devices: devices}
+		containerConfig.Resources, err = parseResources(module.Resources)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(err)
+		}
+		containerConfig.Resources.Devices = devices
+
+		err = checkHostPolicy(containerConfig.MountConfigs, devices)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(err)
+		}
+
+		// the secret files are written by the agent, so their mount is not subject to the host policy
+		if len(secretFiles) > 0 {
+			containerConfig.SecretFiles = secretFiles
+			containerConfig.SecretsDir = filepath.Join(config.Params.SecretsDir, uniqueID.String(), strconv.Itoa(i))
+			containerConfig.MountConfigs = append(containerConfig.MountConfigs, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   containerConfig.SecretsDir,
+				Target:   secretsMountPath,
+				ReadOnly: true,
+			})
+		}
+
+		err = parseSecurity(module.Security, &containerConfig)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(err)
+		}
+		containerConfig.OomScoreAdj = module.Resources.OomScoreAdj
+		containerConfig.DiskRequest = module.Resources.Disk * mb
+		containerConfig.RestartPolicy = parseRestartPolicy(module.Restart)
 
 		containerConfig.ExposedPorts, containerConfig.PortBinding = parsePorts(module.Ports)
 		containerConfigs = append(containerConfigs, containerConfig)
@@ -190,6 +279,25 @@ func GetEdgeAppUniqueID(payload []byte) (model.ManifestUniqueID, error) {
 	return model.ManifestUniqueID{ID: uniqueID.ID}, nil
 }
 
+// GetRollbackVersion returns the updatedAt of the version a rollback message asks for,
+// zero if the message asks for the version before the deployed one
+func GetRollbackVersion(payload []byte) (time.Time, error) {
+	var uniqueID uniqueIDmsg
+	err := json.Unmarshal(payload, &uniqueID)
+	if err != nil {
+		return time.Time{}, traceutility.Wrap(err)
+	}
+
+	if uniqueID.UpdatedAt == "" {
+		return time.Time{}, nil
+	}
+	updatedAt, err := time.Parse(time.RFC3339, uniqueID.UpdatedAt)
+	if err != nil {
+		return time.Time{}, traceutility.Wrap(err)
+	}
+	return updatedAt, nil
+}
+
 func (m Manifest) UpdateManifest(networkName string) {
 	for i, module := range m.Modules {
 		m.Modules[i].NetworkName = networkName
@@ -210,6 +318,8 @@ func (m Manifest) UpdateManifest(networkName string) {
 // makeContainerName is a simple utility to return a standard container name
 // This function appends the pipelineID and containerName with _
 func makeContainerName(networkName string, imageName string, index int) string {
+	// the digest would make the name too long to be used as host name
+	imageName, _, _ = strings.Cut(imageName, "@")
 	containerName := fmt.Sprint(networkName, ".", imageName, ".", index)
 
 	// create regular expression for all alphanumeric characters and _ . -
@@ -225,57 +335,114 @@ func makeContainerName(networkName string, imageName string, index int) string {
 	return containerName
 }
 
-func parseArguments(options []envMsg) ([]string, error) {
+// parseArguments returns the env variables and the values to be delivered as files, by file name
+func parseArguments(options []envMsg) ([]string, map[string]string, error) {
 	log.Debug("Parsing environment arguments")
 
 	var args []string
+	files := make(map[string]string)
 	for _, env := range options {
 		var value string
 		if env.Secret {
 			var err error
 			value, err = secret.DecryptEnv(env.Value)
 			if err != nil {
-				return nil, traceutility.Wrap(err)
+				return nil, nil, traceutility.Wrap(err)
 			}
 		} else {
 			value = env.Value
 		}
+
+		if env.AsFile {
+			if strings.ContainsAny(env.Key, "/\\") || strings.Trim(env.Key, ".") == "" {
+				return nil, nil, fmt.Errorf("env %v cannot be delivered as file, it is not a valid file name", env.Key)
+			}
+			files[env.Key] = value
+			continue
+		}
 		args = append(args, fmt.Sprintf("%v=%v", env.Key, value))
 	}
-	return args, nil
+	return args, files, nil
 }
 
-func parseMounts(mnts []mountMsg) ([]mount.Mount, error) {
+// parseMounts creates bind, volume and tmpfs mounts.
+// Volumes are named after and labelled with the edge app that owns them, so they are shared across versions
+// of the edge app and survive UNDEPLOY, but are removed together with the edge app on REMOVE.
+func parseMounts(mnts []mountMsg, uniqueID model.ManifestUniqueID, labels map[string]string) ([]mount.Mount, error) {
 	log.Debug("Parsing mount points")
 
 	mounts := []mount.Mount{}
 
 	for _, mnt := range mnts {
-		mount := mount.Mount{
-			Type:        "bind",
-			Source:      mnt.Host,
-			Target:      mnt.Container,
-			ReadOnly:    false,
-			Consistency: "default",
-			BindOptions: &mount.BindOptions{Propagation: "rprivate", NonRecursive: true},
+		if mnt.Type != mountTypeTmpfs && strings.TrimSpace(mnt.Host) == "" {
+			return nil, fmt.Errorf("%v mount %v requires a host path or volume name", mnt.Type, mnt.Container)
 		}
 
-		mounts = append(mounts, mount)
+		var mountConfig mount.Mount
+		switch mnt.Type {
+		case mountTypeVolume:
+			mountConfig = mount.Mount{
+				Type:          mount.TypeVolume,
+				Source:        makeVolumeName(uniqueID, mnt.Host),
+				Target:        mnt.Container,
+				ReadOnly:      mnt.ReadOnly,
+				VolumeOptions: &mount.VolumeOptions{Labels: labels},
+			}
+		case mountTypeTmpfs:
+			mountConfig = mount.Mount{
+				Type:         mount.TypeTmpfs,
+				Target:       mnt.Container,
+				ReadOnly:     mnt.ReadOnly,
+				TmpfsOptions: &mount.TmpfsOptions{SizeBytes: mnt.TmpfsSize * mb},
+			}
+		default:
+			mountConfig = mount.Mount{
+				Type:        mount.TypeBind,
+				Source:      mnt.Host,
+				Target:      mnt.Container,
+				ReadOnly:    mnt.ReadOnly,
+				Consistency: "default",
+				BindOptions: &mount.BindOptions{Propagation: "rprivate", NonRecursive: true},
+			}
+		}
+
+		mounts = append(mounts, mountConfig)
 	}
 
 	return mounts, nil
 }
 
+// makeVolumeName returns the name of a volume owned by the edge app
+func makeVolumeName(uniqueID model.ManifestUniqueID, name string) string {
+	reg, err := regexp.Compile("[^A-Za-z0-9_.-]+")
+	if err != nil {
+		log.Fatal("Regular expression parsing failed! CAUSE --> ", err)
+	}
+
+	return uniqueID.String() + "_" + reg.ReplaceAllString(name, "_")
+}
+
 func parseDevices(devs []deviceMsg) ([]container.DeviceMapping, error) {
 	log.Debug("Parsing devices to attach")
 
 	devices := []container.DeviceMapping{}
 
 	for _, dev := range devs {
+		permissions := dev.Permissions
+		if permissions == "" {
+			// the default permissions are reduced to what the node policy allows
+			permissions = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(config.Params.DevicePermissions, r) {
+					return r
+				}
+				return -1
+			}, defaultDevicePermissions)
+		}
+
 		device := container.DeviceMapping{
 			PathOnHost:        dev.Host,
 			PathInContainer:   dev.Container,
-			CgroupPermissions: "rw",
+			CgroupPermissions: permissions,
 		}
 
 		devices = append(devices, device)
@@ -284,6 +451,159 @@ func parseDevices(devs []deviceMsg) ([]container.DeviceMapping, error) {
 	return devices, nil
 }
 
+func parseResources(res resourcesMsg) (container.Resources, error) {
+	log.Debug("Parsing resource limits")
+
+	resources := container.Resources{
+		CPUShares:  res.CPUShares,
+		CPUPeriod:  res.CPUPeriod,
+		CPUQuota:   res.CPUQuota,
+		CpusetCpus: res.CpusetCpus,
+		Memory:     res.Memory * mb,
+		MemorySwap: res.MemorySwap * mb,
+	}
+	if res.MemorySwap < 0 {
+		resources.MemorySwap = -1
+	}
+
+	if res.CPUs > 0 {
+		if res.CPUQuota > 0 || res.CPUPeriod > 0 {
+			return container.Resources{}, errors.New("CPUs and CPU quota/period cannot both be set")
+		}
+		resources.NanoCPUs = int64(res.CPUs * 1e9)
+	}
+
+	if res.PidsLimit != 0 {
+		pidsLimit := res.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+
+	if res.OomKillDisable {
+		if res.Memory == 0 && config.Params.ModuleMaxMemory == 0 {
+			return container.Resources{}, errors.New("OOM killer can only be disabled together with a memory limit")
+		}
+		oomKillDisable := true
+		resources.OomKillDisable = &oomKillDisable
+	}
+
+	for _, ulimit := range res.Ulimits {
+		resources.Ulimits = append(resources.Ulimits, &units.Ulimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard})
+	}
+
+	applyResourceCaps(&resources)
+
+	if resources.MemorySwap > 0 && resources.MemorySwap < resources.Memory {
+		return container.Resources{}, errors.New("memory swap limit must be larger than the memory limit")
+	}
+
+	return resources, nil
+}
+
+// applyResourceCaps enforces the node level caps from the agent config.
+// Modules without a limit get the cap, modules asking for more than the cap are clamped to it.
+func applyResourceCaps(resources *container.Resources) {
+	if maxCPUs := config.Params.ModuleMaxCPUs; maxCPUs > 0 {
+		maxNanoCPUs := int64(maxCPUs * 1e9)
+		if resources.CPUQuota > 0 {
+			if resources.CPUPeriod == 0 {
+				resources.CPUPeriod = defaultCPUPeriod
+			}
+			maxQuota := int64(maxCPUs * float64(resources.CPUPeriod))
+			if resources.CPUQuota > maxQuota {
+				log.Warnf("CPU quota %v exceeds the node cap, clamping to %v", resources.CPUQuota, maxQuota)
+				resources.CPUQuota = maxQuota
+			}
+		} else if resources.NanoCPUs == 0 || resources.NanoCPUs > maxNanoCPUs {
+			if resources.NanoCPUs > maxNanoCPUs {
+				log.Warnf("CPUs %v exceed the node cap, clamping to %v", float64(resources.NanoCPUs)/1e9, maxCPUs)
+			}
+			resources.CPUPeriod = 0
+			resources.NanoCPUs = maxNanoCPUs
+		}
+	}
+
+	if maxMemory := config.Params.ModuleMaxMemory * mb; maxMemory > 0 {
+		if resources.Memory == 0 || resources.Memory > maxMemory {
+			if resources.Memory > maxMemory {
+				log.Warnf("Memory limit %vMB exceeds the node cap, clamping to %vMB", resources.Memory/mb, maxMemory/mb)
+			}
+			resources.Memory = maxMemory
+		}
+		if resources.MemorySwap < 0 {
+			log.Warn("Unlimited swap is not allowed with a node memory cap, disabling swap")
+			resources.MemorySwap = resources.Memory
+		}
+	}
+
+	if maxPids := config.Params.ModuleMaxPids; maxPids > 0 {
+		if resources.PidsLimit == nil || *resources.PidsLimit <= 0 || *resources.PidsLimit > maxPids {
+			if resources.PidsLimit != nil && *resources.PidsLimit > maxPids {
+				log.Warnf("PIDs limit %v exceeds the node cap, clamping to %v", *resources.PidsLimit, maxPids)
+			}
+			resources.PidsLimit = &maxPids
+		}
+	}
+}
+
+func parseRestartPolicy(restart restartPolicyMsg) container.RestartPolicy {
+	if restart.Name == "" {
+		return container.RestartPolicy{
+			Name:              defaultRestartPolicy,
+			MaximumRetryCount: defaultRestartRetries,
+		}
+	}
+
+	policy := container.RestartPolicy{Name: restart.Name}
+	// docker only accepts the retry count together with on-failure
+	if restart.Name == "on-failure" {
+		policy.MaximumRetryCount = restart.MaximumRetryCount
+	}
+
+	return policy
+}
+
+// parseSecurity sets the security options of the container, combining the options requested by the module
+// with the ones enforced by the node
+func parseSecurity(sec securityMsg, containerConfig *ContainerConfig) error {
+	log.Debug("Parsing security options")
+
+	err := checkSecurityPolicy(sec)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	containerConfig.User = sec.User
+	containerConfig.Privileged = sec.Privileged
+	containerConfig.ReadonlyRoot = sec.ReadOnlyRootfs
+
+	if sec.DropAllCapabilities || config.Params.DropAllCapabilities {
+		containerConfig.CapDrop = strslice.StrSlice{"ALL"}
+	}
+	for _, capability := range sec.CapAdd {
+		containerConfig.CapAdd = append(containerConfig.CapAdd, normalizeCapability(capability))
+	}
+
+	containerConfig.SecurityOpt = nil
+	if sec.NoNewPrivileges || config.Params.NoNewPrivileges {
+		containerConfig.SecurityOpt = append(containerConfig.SecurityOpt, "no-new-privileges:true")
+	}
+	if sec.AppArmor != "" {
+		containerConfig.SecurityOpt = append(containerConfig.SecurityOpt, "apparmor="+sec.AppArmor)
+	}
+	if sec.Seccomp == unconfinedProfile {
+		containerConfig.SecurityOpt = append(containerConfig.SecurityOpt, "seccomp="+unconfinedProfile)
+	} else if sec.Seccomp != "" {
+		// docker expects the content of the profile, not its name
+		profile, err := readSeccompProfile(sec.Seccomp)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		containerConfig.SecurityOpt = append(containerConfig.SecurityOpt, "seccomp="+profile)
+	}
+
+	return nil
+}
+
 func parsePorts(ports []portMsg) (nat.PortSet, nat.PortMap) {
 	log.Debug("Parsing ports to bind")
 
@@ -331,14 +651,92 @@ func parseConnections(connectionsStringMap connectionsString) (connectionsInt, e
 	return connectionsIntMap, nil
 }
 
-func clearSecretValues(man Manifest) Manifest {
-	// perform a deep copy, while removing env variables and passwords
+const secretsSealLabel = "manifestSecrets"
+
+// moduleSecrets are the values of a module that never touch the hard disk in plaintext
+type moduleSecrets struct {
+	EnvArgs          []string
+	SecretFiles      map[string]string
+	RegistryPassword string
+}
+
+// ErrSecretsLost is returned for stored manifests whose secret values could not be sealed,
+// the edge app cannot be recreated from them without the manager sending the manifest again
+var ErrSecretsLost = errors.New("the secret values were not stored, because sealing them failed")
+
+// SecretsLost reports whether the stored manifest misses the secret values of a module
+func (m Manifest) SecretsLost() bool {
+	for _, module := range m.Modules {
+		if module.SecretsLost {
+			return true
+		}
+	}
+	return false
+}
+
+func sealSecretValues(man Manifest) Manifest {
+	// perform a deep copy, while replacing env variables and passwords by their sealed form
 	manCopy := man
 	manCopy.Modules = make([]ContainerConfig, len(man.Modules))
 	copy(manCopy.Modules, man.Modules)
 	for i := range manCopy.Modules {
-		manCopy.Modules[i].EnvArgs = nil
-		manCopy.Modules[i].AuthConfig.Password = ""
+		module := &manCopy.Modules[i]
+		if len(module.EnvArgs) == 0 && len(module.SecretFiles) == 0 && module.AuthConfig.Password == "" {
+			continue // nothing to seal, or the values are sealed already
+		}
+
+		secrets, err := json.Marshal(moduleSecrets{
+			EnvArgs:          module.EnvArgs,
+			SecretFiles:      module.SecretFiles,
+			RegistryPassword: module.AuthConfig.Password,
+		})
+		if err == nil {
+			module.SealedSecrets, err = secret.Seal(secrets, secretsSealLabel)
+		}
+		if err != nil {
+			// the edge app cannot be recreated without the manager then, but the values are never stored in plaintext
+			log.Error("Sealing secret values of module ", module.ContainerName, " failed! CAUSE --> ", err)
+			module.SealedSecrets = nil
+			module.SecretsLost = true
+		}
+
+		module.EnvArgs = nil
+		module.SecretFiles = nil
+		module.AuthConfig.Password = ""
 	}
 	return manCopy
 }
+
+// UnsealSecretValues returns a copy of the stored manifest with the env variables and registry passwords restored,
+// so that the edge app can be recreated without the manager sending the manifest again
+func UnsealSecretValues(man Manifest) (Manifest, error) {
+	manCopy := man
+	manCopy.Modules = make([]ContainerConfig, len(man.Modules))
+	copy(manCopy.Modules, man.Modules)
+	for i := range manCopy.Modules {
+		module := &manCopy.Modules[i]
+		if module.SecretsLost {
+			return Manifest{}, fmt.Errorf("module %v: %w", module.ContainerName, ErrSecretsLost)
+		}
+		if module.SealedSecrets == nil {
+			continue
+		}
+
+		plaintext, err := secret.Unseal(module.SealedSecrets, secretsSealLabel)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("failed to unseal secret values of module %v: %w", module.ContainerName, err)
+		}
+
+		var secrets moduleSecrets
+		err = json.Unmarshal(plaintext, &secrets)
+		if err != nil {
+			return Manifest{}, traceutility.Wrap(err)
+		}
+
+		module.EnvArgs = secrets.EnvArgs
+		module.SecretFiles = secrets.SecretFiles
+		module.AuthConfig.Password = secrets.RegistryPassword
+		module.SealedSecrets = nil
+	}
+	return manCopy, nil
+}
@@ -19,12 +19,16 @@ type moduleMsg struct {
 	Mounts     []mountMsg
 	Devices    []deviceMsg
 	Type       string `validate:"required,notblank"`
+	Restart    restartPolicyMsg
+	Resources  resourcesMsg
+	Security   securityMsg
 }
 
 type envMsg struct {
 	Key    string `validate:"required,notblank"`
 	Value  string `validate:"required"`
 	Secret bool   `validate:"required"`
+	AsFile bool   // deliver the value as file /run/secrets/<Key> instead of an env variable
 }
 
 type portMsg struct {
@@ -33,25 +37,72 @@ type portMsg struct {
 }
 
 type mountMsg struct {
+	Type      string `validate:"omitempty,oneof=bind volume tmpfs"`
 	Container string `validate:"required,notblank"`
-	Host      string `validate:"required,notblank"`
+	Host      string `validate:"required_unless=Type tmpfs"` // host path of a bind mount or name of a volume
+	ReadOnly  bool
+	TmpfsSize int64 `validate:"gte=0"` // in MB
 }
 
 type deviceMsg struct {
-	Container string `validate:"required,notblank"`
-	Host      string `validate:"required,notblank"`
+	Container   string `validate:"required,notblank"`
+	Host        string `validate:"required,notblank"`
+	Permissions string `validate:"omitempty,oneof=r rw rwm"`
+}
+
+type restartPolicyMsg struct {
+	Name              string `validate:"omitempty,oneof=no always unless-stopped on-failure"`
+	MaximumRetryCount int    `validate:"gte=0"`
+}
+
+type resourcesMsg struct {
+	CPUs           float64 `validate:"gte=0"` // number of CPUs, e.g. 0.5
+	CPUQuota       int64   `validate:"gte=0"`
+	CPUPeriod      int64   `validate:"gte=0"`
+	CPUShares      int64   `validate:"gte=0"`
+	CpusetCpus     string
+	Memory         int64       `validate:"gte=0"`  // in MB
+	MemorySwap     int64       `validate:"gte=-1"` // in MB, -1 for unlimited swap
+	PidsLimit      int64       `validate:"gte=-1"`
+	Ulimits        []ulimitMsg `validate:"dive"`
+	OomKillDisable bool
+	OomScoreAdj    int   `validate:"gte=-1000,lte=1000"`
+	Disk           int64 `validate:"gte=0"` // in MB, disk space reserved for the module
+}
+
+type ulimitMsg struct {
+	Name string `validate:"required,notblank"`
+	Soft int64
+	Hard int64
+}
+
+type securityMsg struct {
+	Privileged          bool
+	DropAllCapabilities bool
+	CapAdd              []string `validate:"dive,required,notblank"`
+	NoNewPrivileges     bool
+	ReadOnlyRootfs      bool
+	Seccomp             string // name of a seccomp profile on the node or "unconfined"
+	AppArmor            string // name of an AppArmor profile loaded on the node or "unconfined"
+	User                string // user[:group] to run the container as
 }
 
 type imageMsg struct {
-	Name     string `validate:"required,notblank"`
-	Tag      string
-	Registry registryMsg
+	Name             string `validate:"required,notblank"`
+	Tag              string
+	Digest           string `validate:"omitempty,startswith=sha256:,len=71"`
+	Signature        string `validate:"omitempty,base64"` // signature of the digest or of the signature payload
+	SignaturePayload string `validate:"omitempty,base64"` // cosign simple signing payload
+	Archive          string // path on the node of an archive created by docker save, relative to the image import directory
+	ArchiveSha256    string `validate:"required_with=Archive,omitempty,len=64,hexadecimal"`
+	Registry         registryMsg
 }
 
 type registryMsg struct {
-	Url      string `validate:"required,notblank"`
-	UserName string
-	Password string
+	Url            string `validate:"required,notblank"`
+	UserName       string
+	Password       string
+	CredentialName string // name of a registry credential stored on the node, used instead of UserName and Password
 }
 
 type uniqueIDmsg struct {
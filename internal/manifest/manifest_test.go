@@ -3,15 +3,22 @@ package manifest_test
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/beetaone/beeta-agent/internal/config"
 	"github.com/beetaone/beeta-agent/internal/manifest"
+	"github.com/beetaone/beeta-agent/internal/model"
+	"github.com/beetaone/beeta-agent/internal/secret"
 )
 
 var manifestUniqueID struct {
@@ -36,7 +43,11 @@ func TestGetManifest(t *testing.T) {
 	assert.Equal(3, len(manifest.Connections))
 	assert.Equal(4, len(manifest.Modules))
 
-	assert.Equal(1, len(manifest.Modules[0].Labels))
+	assert.Equal(map[string]string{
+		"manifestUniqueID": "62bef68d664ed72f8ecdd690",
+		"manifestName":     "kunbus-demo-manifest",
+		"updatedAt":        "2023-01-01T00:00:00Z",
+	}, manifest.Modules[0].Labels)
 	assert.Equal("beetanetwork/mqtt-ingress:V1", manifest.Modules[0].ImageNameFull)
 	assert.ElementsMatch(manifest.Modules[0].EnvArgs, []string{
 		"MQTT_BROKER=mqtt://mapi-dev.beeta.engineering",
@@ -83,6 +94,549 @@ func TestGetManifest(t *testing.T) {
 	assert.Contains(manifest.Modules[0].EnvArgs, "EGRESS_URLS=http://kunbus-demo-manifest_1d.beetanetwork_fluctuation-filter_V1.1:80/")
 }
 
+func TestGetManifest_Resources(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/resourcesManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited := manifest.Modules[0]
+	assert.Equal(container.RestartPolicy{Name: "always"}, limited.RestartPolicy)
+	assert.Equal(int64(500000000), limited.Resources.NanoCPUs)
+	assert.Equal(int64(512), limited.Resources.CPUShares)
+	assert.Equal("0,1", limited.Resources.CpusetCpus)
+	assert.Equal(int64(128*1024*1024), limited.Resources.Memory)
+	assert.Equal(int64(256*1024*1024), limited.Resources.MemorySwap)
+	assert.Equal(int64(100), *limited.Resources.PidsLimit)
+	assert.Equal([]*units.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}}, limited.Resources.Ulimits)
+	assert.True(*limited.Resources.OomKillDisable)
+	assert.Equal(500, limited.OomScoreAdj)
+
+	unlimited := manifest.Modules[1]
+	assert.Equal(container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 100}, unlimited.RestartPolicy)
+	assert.Equal(int64(0), unlimited.Resources.NanoCPUs)
+	assert.Equal(int64(0), unlimited.Resources.Memory)
+	assert.Nil(unlimited.Resources.PidsLimit)
+}
+
+func TestGetManifest_ResourceCaps(t *testing.T) {
+	assert := assert.New(t)
+
+	config.Params.ModuleMaxCPUs = 0.25
+	config.Params.ModuleMaxMemory = 64
+	config.Params.ModuleMaxPids = 50
+	defer func() {
+		config.Params.ModuleMaxCPUs = 0
+		config.Params.ModuleMaxMemory = 0
+		config.Params.ModuleMaxPids = 0
+	}()
+
+	json, err := os.ReadFile("../../testdata/unittests/resourcesManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, module := range manifest.Modules {
+		assert.Equal(int64(250000000), module.Resources.NanoCPUs)
+		assert.Equal(int64(64*1024*1024), module.Resources.Memory)
+		assert.Equal(int64(50), *module.Resources.PidsLimit)
+	}
+}
+
+func TestGetManifest_Mounts(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/mountsManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal([]mount.Mount{
+		{
+			Type:        "bind",
+			Source:      "/etc/app",
+			Target:      "/config",
+			ReadOnly:    true,
+			Consistency: "default",
+			BindOptions: &mount.BindOptions{Propagation: "rprivate", NonRecursive: true},
+		},
+		{
+			Type:          "volume",
+			Source:        "62bef68d664ed72f8ecdd692_app_data",
+			Target:        "/data",
+			VolumeOptions: &mount.VolumeOptions{Labels: map[string]string{"manifestUniqueID": "62bef68d664ed72f8ecdd692"}},
+		},
+		{
+			Type:         "tmpfs",
+			Target:       "/cache",
+			TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 16 * 1024 * 1024},
+		},
+	}, manifest.Modules[0].MountConfigs)
+}
+
+func TestValidateManifest_HostPolicy(t *testing.T) {
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+	filePath := "../../testdata/unittests/mvpManifest.json"
+
+	config.Params.AllowedHostPaths = []string{"/data/host"}
+	config.Params.AllowedDevices = []string{"/dev/ttyUSB*/*"}
+	json, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = manifest.Parse(json)
+	assert.Nil(t, err)
+
+	config.Params.AllowedHostPaths = []string{"/data/hostile"}
+	utilFailTestValidateManifest(t, filePath, "mount of host path /data/host is not allowed by the node policy")
+
+	config.Params.AllowedHostPaths = nil
+	config.Params.ReadOnlyHostMounts = true
+	utilFailTestValidateManifest(t, filePath, "mount of host path /data/host must be read-only")
+
+	config.Params.ReadOnlyHostMounts = false
+	config.Params.AllowedDevices = []string{"/dev/ttyACM*"}
+	utilFailTestValidateManifest(t, filePath, "device /dev/ttyUSB0/host is not allowed by the node policy")
+
+	config.Params.AllowedDevices = nil
+	config.Params.DevicePermissions = "r"
+	manifest, err := manifest.Parse(json)
+	assert.Nil(t, err)
+	assert.Equal(t, "r", manifest.Modules[0].Resources.Devices[0].CgroupPermissions)
+}
+
+func TestGetManifest_Security(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/securityManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := manifest.Modules[0]
+	assert.Equal("1000:1000", module.User)
+	assert.False(module.Privileged)
+	assert.True(module.ReadonlyRoot)
+	assert.Equal(strslice.StrSlice{"ALL"}, module.CapDrop)
+	assert.Equal(strslice.StrSlice{"NET_BIND_SERVICE"}, module.CapAdd)
+	assert.Equal([]string{"no-new-privileges:true", "apparmor=docker-default"}, module.SecurityOpt)
+}
+
+func TestValidateManifest_SecurityPolicy(t *testing.T) {
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+
+	utilFailTestValidateManifest(t, "../../testdata/unittests/failPrivilegedModule.json", "privileged containers are not allowed by the node policy")
+
+	config.Params.AllowedCapabilities = []string{"CAP_CHOWN"}
+	utilFailTestValidateManifest(t, "../../testdata/unittests/securityManifest.json", "capability NET_BIND_SERVICE is not allowed by the node policy")
+}
+
+func TestGetManifest_Digest(t *testing.T) {
+	assert := assert.New(t)
+
+	json, err := os.ReadFile("../../testdata/unittests/digestManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "sha256:abababababababababababababababababababababababababababababababab"
+	assert.Equal("beetanetwork/fluctuation-filter:V1@"+digest, manifest.Modules[0].ImageNameFull)
+	assert.Equal(digest, manifest.Modules[0].ImageDigest)
+	assert.Contains(manifest.Modules[0].EnvArgs, "MODULE_NAME=beetanetwork/fluctuation-filter:V1")
+
+	manifest.UpdateManifest("digest-manifest_001")
+	assert.Equal("digest-manifest_001.beetanetwork_fluctuation-filter_V1.0", manifest.Modules[0].ContainerName)
+}
+
+func TestGetManifest_ImageArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+	config.Params.ImageImportDir = "/var/lib/beeta-agent/images"
+
+	json, err := os.ReadFile("../../testdata/unittests/archiveManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal("/var/lib/beeta-agent/images/fluctuation-filter.tar", manifest.Modules[0].ImageArchive)
+	assert.Equal("abababababababababababababababababababababababababababababababab", manifest.Modules[0].ImageArchiveSha256)
+	assert.Equal("/media/usb/comparison-filter.tar.gz", manifest.Modules[1].ImageArchive)
+}
+
+func TestGetManifest_SecretFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+	config.Params.SecretsDir = "/run/beeta-agent/secrets"
+
+	json, err := os.ReadFile("../../testdata/unittests/secretFilesManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := manifest.Modules[0]
+	assert.Contains(module.EnvArgs, "API_URL=https://example.com")
+	assert.NotContains(module.EnvArgs, "API_TOKEN=token")
+	assert.Equal(map[string]string{"API_TOKEN": "token"}, module.SecretFiles)
+	assert.Equal("/run/beeta-agent/secrets/62bef68d664ed72f8ecdd697/0", module.SecretsDir)
+	assert.Contains(module.MountConfigs, mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   "/run/beeta-agent/secrets/62bef68d664ed72f8ecdd697/0",
+		Target:   "/run/secrets",
+		ReadOnly: true,
+	})
+}
+
+func TestAddKnownManifest_SealsSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	// the node keypair is written to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := os.ReadFile("../../testdata/unittests/mvpManifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secret.InitNodeKeypair(); err != nil {
+		t.Fatal(err)
+	}
+
+	man, err := manifest.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+	man.Modules[0].AuthConfig.Password = "registry password"
+
+	manifest.AddKnownManifest(man)
+	defer manifest.DeleteKnownManifest(man.UniqueID)
+
+	stored := manifest.GetKnownManifest(man.UniqueID).Manifest
+	assert.Nil(stored.Modules[0].EnvArgs)
+	assert.Empty(stored.Modules[0].AuthConfig.Password)
+	assert.NotNil(stored.Modules[0].SealedSecrets)
+	assert.NotContains(string(stored.Modules[0].SealedSecrets), "registry password")
+
+	restored, err := manifest.UnsealSecretValues(stored)
+	assert.Nil(err)
+	assert.Equal(man.Modules[0].EnvArgs, restored.Modules[0].EnvArgs)
+	assert.Equal("registry password", restored.Modules[0].AuthConfig.Password)
+	assert.Nil(restored.Modules[0].SealedSecrets)
+}
+
+func TestUnsealSecretValues_SecretsLost(t *testing.T) {
+	assert := assert.New(t)
+
+	// sealing failed when the manifest was stored
+	stored := manifest.Manifest{Modules: []manifest.ContainerConfig{{ContainerName: "app.0"}, {ContainerName: "app.1", SecretsLost: true}}}
+	assert.True(stored.SecretsLost())
+
+	_, err := manifest.UnsealSecretValues(stored)
+	assert.ErrorIs(err, manifest.ErrSecretsLost)
+}
+
+func TestInitKnownManifests_Migration(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	// written before the schema version was introduced
+	legacy := `{"legacyApp": {"Manifest": {"UniqueID": "legacyApp", "ID": "legacyApp"}, "Status": "Running", "LastLogReadTime": ""}}`
+	if err := os.WriteFile(manifest.ManifestFile, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(manifest.InitKnownManifests())
+	uniqueID := model.ManifestUniqueID{ID: "legacyApp"}
+	defer manifest.DeleteKnownManifest(uniqueID)
+
+	record := manifest.GetKnownManifest(uniqueID)
+	if assert.NotNil(record) {
+		assert.Equal(model.EdgeAppRunning, record.Status)
+	}
+
+	assert.Nil(manifest.SetStatus(uniqueID, model.EdgeAppStopped))
+	content, err := os.ReadFile(manifest.ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(string(content), `"schemaVersion": 1`)
+	_, err = os.Stat(manifest.ManifestFile + ".tmp")
+	assert.True(os.IsNotExist(err))
+
+	assert.Nil(manifest.InitKnownManifests())
+	assert.Equal(model.EdgeAppStopped, manifest.GetKnownManifest(uniqueID).Status)
+}
+
+func TestInitKnownManifests_CorruptFile(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	// cut off by a crash mid-write
+	if err := os.WriteFile(manifest.ManifestFile, []byte(`{"schemaVersion": 1, "data": {"app": {"Manif`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(manifest.InitKnownManifests())
+	assert.True(manifest.KnownManifestsLost())
+	assert.Empty(manifest.GetKnownManifests())
+
+	_, err = os.Stat(manifest.ManifestFile)
+	assert.True(os.IsNotExist(err))
+	quarantined, err := filepath.Glob(manifest.ManifestFile + ".corrupt-*")
+	assert.Nil(err)
+	assert.Len(quarantined, 1)
+}
+
+func TestInitKnownManifests_BoltBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := `{"schemaVersion": 1, "data": {"boltApp": {"Manifest": {"UniqueID": "boltApp", "ID": "boltApp"}, "Status": "Running"}}}`
+	if err := os.WriteFile(manifest.ManifestFile, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(backend string) { config.Params.StateBackend = backend }(config.Params.StateBackend)
+	config.Params.StateBackend = manifest.StateBackendBolt
+
+	// the JSON file is imported into the database
+	assert.Nil(manifest.InitKnownManifests())
+	_, err = os.Stat(manifest.ManifestFile + ".migrated")
+	assert.Nil(err)
+	uniqueID := model.ManifestUniqueID{ID: "boltApp"}
+	if assert.NotNil(manifest.GetKnownManifest(uniqueID)) {
+		assert.Equal(model.EdgeAppRunning, manifest.GetKnownManifest(uniqueID).Status)
+	}
+
+	assert.Nil(manifest.SetStatus(uniqueID, model.EdgeAppStopped))
+	assert.Nil(manifest.SetLastLogRead(uniqueID, "2023-01-01T00:00:00Z"))
+
+	assert.Nil(manifest.InitKnownManifests())
+	record := manifest.GetKnownManifest(uniqueID)
+	if assert.NotNil(record) {
+		assert.Equal(model.EdgeAppStopped, record.Status)
+		assert.Equal("2023-01-01T00:00:00Z", record.LastLogReadTime)
+	}
+
+	manifest.DeleteKnownManifest(uniqueID)
+	assert.Nil(manifest.InitKnownManifests())
+	assert.Nil(manifest.GetKnownManifest(uniqueID))
+
+	config.Params.StateBackend = manifest.StateBackendFile
+	assert.Nil(manifest.InitKnownManifests())
+}
+
+func TestInitKnownManifests_BoltCorruptFile(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(backend string) { config.Params.StateBackend = backend }(config.Params.StateBackend)
+	config.Params.StateBackend = manifest.StateBackendBolt
+
+	// a database that cannot be opened for another reason than corruption is left alone
+	if err := os.Mkdir(manifest.BoltFile, 0700); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(manifest.InitKnownManifests())
+	info, err := os.Stat(manifest.BoltFile)
+	assert.Nil(err)
+	assert.True(info.IsDir())
+
+	// a file that is not a bolt database is moved aside
+	if err := os.Remove(manifest.BoltFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifest.BoltFile, make([]byte, 16*1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(manifest.InitKnownManifests())
+	assert.True(manifest.KnownManifestsLost())
+	quarantined, err := filepath.Glob(manifest.BoltFile + ".corrupt-*")
+	assert.Nil(err)
+	assert.Len(quarantined, 1)
+
+	config.Params.StateBackend = manifest.StateBackendFile
+	assert.Nil(manifest.InitKnownManifests())
+}
+
+func TestGetKnownManifests_Copy(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(manifest.InitKnownManifests())
+
+	uniqueID := model.ManifestUniqueID{ID: "copyApp"}
+	manifest.AddKnownManifest(manifest.Manifest{UniqueID: uniqueID, ID: uniqueID.ID})
+
+	// the records are read by the heartbeat and the image garbage collection while the edge apps are deployed
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.Nil(manifest.SetStatus(uniqueID, model.EdgeAppRunning))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, record := range manifest.GetKnownManifests() {
+				_ = record.Status
+			}
+		}
+	}()
+	wg.Wait()
+
+	manifest.GetKnownManifests()[uniqueID].Status = model.EdgeAppError
+	manifest.GetKnownManifest(uniqueID).Status = model.EdgeAppError
+	status, err := manifest.GetEdgeAppStatus(uniqueID)
+	assert.Nil(err)
+	assert.Equal(model.EdgeAppRunning, status)
+	assert.Nil(manifest.GetKnownManifest(model.ManifestUniqueID{ID: "unknownApp"}))
+}
+
+func TestAddHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(manifest.InitKnownManifests())
+
+	defer func(versions int) { config.Params.HistoryVersions = versions }(config.Params.HistoryVersions)
+	config.Params.HistoryVersions = 2
+
+	uniqueID := model.ManifestUniqueID{ID: "historyApp"}
+	deploy := func(updatedAt string, image string) {
+		version, err := time.Parse(time.RFC3339, updatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.AddKnownManifest(manifest.Manifest{
+			UniqueID:  uniqueID,
+			ID:        uniqueID.ID,
+			UpdatedAt: version,
+			Modules:   []manifest.ContainerConfig{{ImageNameFull: image}},
+		})
+		assert.Nil(manifest.AddHistory(uniqueID))
+	}
+
+	deploy("2023-01-01T00:00:00Z", "app:1")
+	deploy("2023-02-01T00:00:00Z", "app:2")
+	// a rollback redeploys a version that is in the history already
+	deploy("2023-01-01T00:00:00Z", "app:1")
+	versions := manifest.GetHistoryVersions(uniqueID)
+	if assert.Len(versions, 2) {
+		assert.Equal("2023-01-01T00:00:00Z", versions[0].Format(time.RFC3339))
+		assert.Equal("2023-02-01T00:00:00Z", versions[1].Format(time.RFC3339))
+	}
+
+	// the oldest version is dropped
+	deploy("2023-03-01T00:00:00Z", "app:3")
+	assert.Equal([]string{"app:2", "app:3"}, manifest.GetHistoryImages(uniqueID))
+
+	// the history survives a restart
+	assert.Nil(manifest.InitKnownManifests())
+	assert.Len(manifest.GetHistoryVersions(uniqueID), 2)
+
+	assert.Nil(manifest.DeleteHistory(uniqueID))
+	assert.Empty(manifest.GetHistoryImages(uniqueID))
+
+	updatedAt, err := manifest.GetRollbackVersion([]byte(`{"command": "ROLLBACK", "_id": "historyApp", "updatedAt": "2023-02-01T00:00:00Z"}`))
+	assert.Nil(err)
+	assert.Equal("2023-02-01T00:00:00Z", updatedAt.Format(time.RFC3339))
+	updatedAt, err = manifest.GetRollbackVersion([]byte(`{"command": "ROLLBACK", "_id": "historyApp"}`))
+	assert.Nil(err)
+	assert.True(updatedAt.IsZero())
+}
+
 func TestGetEdgeAppUniqueID(t *testing.T) {
 	assert := assert.New(t)
 
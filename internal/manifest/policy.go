@@ -0,0 +1,156 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+)
+
+// checkHostPolicy verifies that the module only accesses the host paths and devices allowed by the node's security policy
+func checkHostPolicy(mounts []mount.Mount, devices []container.DeviceMapping) error {
+	for _, mnt := range mounts {
+		if mnt.Type != mount.TypeBind {
+			continue
+		}
+
+		if config.Params.ReadOnlyHostMounts && !mnt.ReadOnly {
+			return fmt.Errorf("mount of host path %v must be read-only", mnt.Source)
+		}
+
+		if len(config.Params.AllowedHostPaths) > 0 && !isHostPathAllowed(mnt.Source) {
+			return fmt.Errorf("mount of host path %v is not allowed by the node policy", mnt.Source)
+		}
+	}
+
+	for _, dev := range devices {
+		if !permissionsAllowed(dev.CgroupPermissions) {
+			return fmt.Errorf("permissions %v of device %v exceed the permissions %v allowed by the node policy",
+				dev.CgroupPermissions, dev.PathOnHost, config.Params.DevicePermissions)
+		}
+
+		if len(config.Params.AllowedDevices) > 0 && !isDeviceAllowed(dev.PathOnHost) {
+			return fmt.Errorf("device %v is not allowed by the node policy", dev.PathOnHost)
+		}
+	}
+
+	return nil
+}
+
+// isHostPathAllowed checks if the path, with all symlinks resolved, is one of the allowed paths or lies below one of them
+func isHostPathAllowed(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	path = resolvePath(path)
+
+	for _, allowed := range config.Params.AllowedHostPaths {
+		allowed = filepath.Clean(allowed)
+		if path == allowed || strings.HasPrefix(path, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDeviceAllowed checks if the device path, with all symlinks resolved, matches one of the allowed patterns
+func isDeviceAllowed(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	path = resolvePath(path)
+
+	for _, pattern := range config.Params.AllowedDevices {
+		if matched, err := filepath.Match(pattern, path); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePath cleans the path and resolves symlinks, paths that do not exist (yet) are only cleaned
+func resolvePath(path string) string {
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// permissionsAllowed checks that every requested cgroup permission is also granted by the node policy
+func permissionsAllowed(permissions string) bool {
+	for _, permission := range permissions {
+		if !strings.ContainsRune(config.Params.DevicePermissions, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSecurityPolicy rejects modules asking for security options the node policy forbids
+func checkSecurityPolicy(sec securityMsg) error {
+	if !config.Params.AllowPrivileged {
+		if sec.Privileged {
+			return errors.New("privileged containers are not allowed by the node policy")
+		}
+		if sec.Seccomp == unconfinedProfile || sec.AppArmor == unconfinedProfile {
+			return errors.New("unconfined security profiles are not allowed by the node policy")
+		}
+	}
+
+	for _, capability := range sec.CapAdd {
+		capability = normalizeCapability(capability)
+		if capability == "ALL" && !config.Params.AllowPrivileged {
+			return errors.New("adding all capabilities is not allowed by the node policy")
+		}
+		if len(config.Params.AllowedCapabilities) > 0 && !isCapabilityAllowed(capability) {
+			return fmt.Errorf("capability %v is not allowed by the node policy", capability)
+		}
+	}
+
+	return nil
+}
+
+func isCapabilityAllowed(capability string) bool {
+	for _, allowed := range config.Params.AllowedCapabilities {
+		if normalizeCapability(allowed) == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeCapability returns the capability in the form docker reports it, e.g. NET_ADMIN for cap_net_admin
+func normalizeCapability(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(capability)), "CAP_")
+}
+
+var profileNameRegexp = regexp.MustCompile("^[A-Za-z0-9_.-]+$")
+
+// readSeccompProfile reads the seccomp profile with the given name from the profiles directory of the node
+func readSeccompProfile(name string) (string, error) {
+	if !profileNameRegexp.MatchString(name) || strings.Trim(name, ".") == "" {
+		return "", fmt.Errorf("invalid seccomp profile name %v", name)
+	}
+
+	profile, err := os.ReadFile(filepath.Join(config.Params.SeccompProfilesDir, name+".json"))
+	if err != nil {
+		return "", fmt.Errorf("seccomp profile %v is not available on the node: %w", name, err)
+	}
+
+	if !json.Valid(profile) {
+		return "", fmt.Errorf("seccomp profile %v is not valid JSON", name)
+	}
+
+	return string(profile), nil
+}
@@ -1,14 +1,12 @@
 package manifest
 
 import (
-	"encoding/json"
-	"io"
-	"os"
-
 	"errors"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/beetaone/beeta-agent/internal/config"
 	"github.com/beetaone/beeta-agent/internal/model"
 	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
 )
@@ -17,21 +15,53 @@ type ManifestRecord struct {
 	Manifest        Manifest
 	Status          string
 	LastLogReadTime string
+	// Rebuilt is set if the manifest was rebuilt from the edge app containers after the known manifests were lost.
+	// It only holds what the containers tell, the edge app cannot be redeployed from it and its UpdatedAt may be zero.
+	Rebuilt bool
 }
 
 var knownManifests = make(map[model.ManifestUniqueID]*ManifestRecord)
 
-const ManifestFile = "known_manifests.jsonl"
+// stagedManifests holds the manifests whose images were prefetched, but which are not deployed yet
+var stagedManifests = make(map[model.ManifestUniqueID]Manifest)
+
+// knownManifestsLost is set if the known manifests could not be read on startup
+var knownManifestsLost bool
+
+// manifestsLock guards knownManifests and stagedManifests, which are also read by the heartbeat, the log sender
+// and the image garbage collection
+var manifestsLock sync.RWMutex
 
+// GetKnownManifests returns a copy of the known manifests, changes to the records are not stored
 func GetKnownManifests() map[model.ManifestUniqueID]*ManifestRecord {
-	return knownManifests
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
+	known := make(map[model.ManifestUniqueID]*ManifestRecord, len(knownManifests))
+	for uniqueID, record := range knownManifests {
+		recordCopy := *record
+		known[uniqueID] = &recordCopy
+	}
+	return known
 }
 
+// GetKnownManifest returns a copy of the known manifest or nil if the edge app is not known
 func GetKnownManifest(manifestUniqueID model.ManifestUniqueID) *ManifestRecord {
-	return knownManifests[manifestUniqueID]
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
+	record, known := knownManifests[manifestUniqueID]
+	if !known {
+		return nil
+	}
+	recordCopy := *record
+	return &recordCopy
 }
 
 func GetUsedImages(uniqueID model.ManifestUniqueID) ([]string, error) {
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
 	var images []string
 	manifest, manifestKnown := knownManifests[uniqueID]
 	if !manifestKnown {
@@ -44,7 +74,10 @@ func GetUsedImages(uniqueID model.ManifestUniqueID) ([]string, error) {
 }
 
 func AddKnownManifest(man Manifest) {
-	manCopy := clearSecretValues(man) // remove some fields so that secret values never touch the hard disk
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	manCopy := sealSecretValues(man) // seal some fields so that secret values never touch the hard disk in plaintext
 	knownManifests[man.UniqueID] = &ManifestRecord{
 		Manifest: manCopy,
 		Status:   model.EdgeAppInitiated,
@@ -52,26 +85,39 @@ func AddKnownManifest(man Manifest) {
 }
 
 func DeleteKnownManifest(manifestUniqueID model.ManifestUniqueID) {
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	record, known := knownManifests[manifestUniqueID]
+	if !known {
+		return
+	}
 	delete(knownManifests, manifestUniqueID)
 
-	err := writeKnownManifestsToFile()
+	err := store.DeleteKnown(manifestUniqueID)
 	if err != nil {
-		log.Fatal("Failed to write known manifest to file! CAUSE --> ", err)
+		knownManifests[manifestUniqueID] = record
+		log.Error("Failed to write known manifest to file! CAUSE --> ", err)
 	}
 }
 
 func SetStatus(manifestUniqueID model.ManifestUniqueID, status string) error {
 	log.Debugln("Setting status", status, "to edge app", manifestUniqueID)
 
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
 	manifest, manifestKnown := knownManifests[manifestUniqueID]
 	if !manifestKnown {
 		return errors.New("could not set the status. the edge app is not known (deployed)")
 	}
+	previous := manifest.Status
 	manifest.Status = status
 
-	err := writeKnownManifestsToFile()
+	err := store.PutKnown(manifest)
 	if err != nil {
-		log.Fatal("Failed to write known manifest to file! CAUSE --> ", err)
+		manifest.Status = previous
+		return traceutility.Wrap(err)
 	}
 	return nil
 }
@@ -79,57 +125,159 @@ func SetStatus(manifestUniqueID model.ManifestUniqueID, status string) error {
 func SetLastLogRead(manifestUniqueID model.ManifestUniqueID, lastLogReadTime string) error {
 	log.Debugln("Setting last log read time", lastLogReadTime, "to edge app", manifestUniqueID)
 
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
 	manifest, manifestKnown := knownManifests[manifestUniqueID]
 	if !manifestKnown {
 		return errors.New("could not set the status. the edge app is not known (deployed)")
 	}
+	previous := manifest.LastLogReadTime
 	manifest.LastLogReadTime = lastLogReadTime
 
-	err := writeKnownManifestsToFile()
+	err := store.PutKnown(manifest)
 	if err != nil {
-		log.Fatal("Failed to write known manifest to file! CAUSE --> ", err)
+		manifest.LastLogReadTime = previous
+		return traceutility.Wrap(err)
 	}
 	return nil
 }
 
-func InitKnownManifests() error {
-	log.Debug("Initializing known manifests...")
+// GetStagedManifests returns a copy of the staged manifests
+func GetStagedManifests() map[model.ManifestUniqueID]Manifest {
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
 
-	jsonFile, err := os.Open(ManifestFile)
-	if os.IsNotExist(err) {
-		return nil
+	staged := make(map[model.ManifestUniqueID]Manifest, len(stagedManifests))
+	for uniqueID, man := range stagedManifests {
+		staged[uniqueID] = man
 	}
+	return staged
+}
+
+func GetStagedManifest(manifestUniqueID model.ManifestUniqueID) (Manifest, bool) {
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
+	man, staged := stagedManifests[manifestUniqueID]
+	return man, staged
+}
+
+func AddStagedManifest(man Manifest) error {
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	previous, staged := stagedManifests[man.UniqueID]
+	stagedManifests[man.UniqueID] = sealSecretValues(man) // secret values never touch the hard disk in plaintext
+
+	err := store.PutStaged(stagedManifests[man.UniqueID])
 	if err != nil {
+		if staged {
+			stagedManifests[man.UniqueID] = previous
+		} else {
+			delete(stagedManifests, man.UniqueID)
+		}
 		return traceutility.Wrap(err)
 	}
-	defer jsonFile.Close()
+	return nil
+}
 
-	byteValue, err := io.ReadAll(jsonFile)
-	if err != nil {
-		return traceutility.Wrap(err)
+func DeleteStagedManifest(manifestUniqueID model.ManifestUniqueID) {
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	if _, staged := stagedManifests[manifestUniqueID]; !staged {
+		return
 	}
+	previous := stagedManifests[manifestUniqueID]
+	delete(stagedManifests, manifestUniqueID)
 
-	return json.Unmarshal(byteValue, &knownManifests)
+	err := store.DeleteStaged(manifestUniqueID)
+	if err != nil {
+		stagedManifests[manifestUniqueID] = previous
+		log.Error("Failed to write staged manifest to file! CAUSE --> ", err)
+	}
 }
 
-func GetEdgeAppStatus(manifestUniqueID model.ManifestUniqueID) (string, error) {
-	manifest, manifestKnown := knownManifests[manifestUniqueID]
-	if !manifestKnown || manifest == nil {
-		return "", errors.New("could not get the status. the edge app " + manifestUniqueID.String() + " is not known")
+// GetStagedImages returns the images of all staged manifests
+func GetStagedImages() []string {
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
+	var images []string
+	for _, man := range stagedManifests {
+		for _, module := range man.Modules {
+			images = append(images, module.ImageNameFull)
+		}
 	}
-	return manifest.Status, nil
+	return images
 }
 
-func writeKnownManifestsToFile() error {
-	encodedJson, err := json.MarshalIndent(knownManifests, "", " ")
+func InitKnownManifests() error {
+	log.Debug("Initializing known manifests...")
+
+	backend, err := newStore(config.Params.StateBackend)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
 
-	err = os.WriteFile(ManifestFile, encodedJson, 0644)
+	// the database of the previous store has to be released before it is opened again
+	store.Close()
+
+	known, staged, lost, err := backend.Load()
 	if err != nil {
+		backend.Close()
 		return traceutility.Wrap(err)
 	}
 
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	store = backend
+	knownManifests = known
+	stagedManifests = staged
+	knownManifestsLost = lost
+
+	return nil
+}
+
+// CloseKnownManifests closes the store of the manifests
+func CloseKnownManifests() error {
+	return store.Close()
+}
+
+// KnownManifestsLost reports whether the known manifests file was corrupt on startup,
+// in which case the known manifests have to be rebuilt from the edge app containers
+func KnownManifestsLost() bool {
+	return knownManifestsLost
+}
+
+// AddRecoveredManifest adds a manifest rebuilt from the edge app containers with the status of its containers
+func AddRecoveredManifest(man Manifest, status string) error {
+	manifestsLock.Lock()
+	defer manifestsLock.Unlock()
+
+	knownManifests[man.UniqueID] = &ManifestRecord{
+		Manifest: man,
+		Status:   status,
+		Rebuilt:  true,
+	}
+
+	err := store.PutKnown(knownManifests[man.UniqueID])
+	if err != nil {
+		delete(knownManifests, man.UniqueID)
+		return traceutility.Wrap(err)
+	}
 	return nil
 }
+
+func GetEdgeAppStatus(manifestUniqueID model.ManifestUniqueID) (string, error) {
+	manifestsLock.RLock()
+	defer manifestsLock.RUnlock()
+
+	manifest, manifestKnown := knownManifests[manifestUniqueID]
+	if !manifestKnown || manifest == nil {
+		return "", errors.New("could not get the status. the edge app " + manifestUniqueID.String() + " is not known")
+	}
+	return manifest.Status, nil
+}
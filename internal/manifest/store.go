@@ -0,0 +1,42 @@
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/beetaone/beeta-agent/internal/model"
+)
+
+const (
+	StateBackendFile = "file"
+	StateBackendBolt = "bolt"
+)
+
+// Store persists the known and staged manifests and the deployment history of the edge apps
+type Store interface {
+	// Load returns the stored manifests, lost is set if the known manifests could not be read and were moved aside
+	Load() (known map[model.ManifestUniqueID]*ManifestRecord, staged map[model.ManifestUniqueID]Manifest, lost bool, err error)
+	PutKnown(record *ManifestRecord) error
+	DeleteKnown(manifestUniqueID model.ManifestUniqueID) error
+	PutStaged(man Manifest) error
+	DeleteStaged(manifestUniqueID model.ManifestUniqueID) error
+	// AddHistory appends the manifest to the history of its edge app and drops all but the newest keep versions
+	AddHistory(man Manifest, keep int) error
+	// GetHistory returns the history of the edge app, oldest version first
+	GetHistory(manifestUniqueID model.ManifestUniqueID) ([]Manifest, error)
+	DeleteHistory(manifestUniqueID model.ManifestUniqueID) error
+	Close() error
+}
+
+// store is replaced by InitKnownManifests with the configured backend
+var store Store = newFileStore()
+
+func newStore(backend string) (Store, error) {
+	switch backend {
+	case StateBackendFile:
+		return newFileStore(), nil
+	case StateBackendBolt:
+		return newBoltStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend %v", backend)
+	}
+}
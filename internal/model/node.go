@@ -3,26 +3,49 @@ package model
 var Version string = "X.Y.Z"
 
 type Params struct {
-	Version      bool   `long:"version" short:"v" description:"Print version information and exit"`
-	Broker       string `long:"broker" short:"b" description:"Broker to connect"`
-	NodeId       string `long:"id" short:"i" description:"ID of this node"`
-	NodeName     string `long:"name" short:"n" description:"Name of this node to be registered"`
-	NoTLS        bool   `long:"notls" description:"For developer - disable TLS for MQTT"`
-	Password     string `long:"password" description:"Password for TLS"`
-	RootCertPath string `long:"rootcert" description:"Path to MQTT broker (server) certificate"`
-	LogLevel     string `long:"loglevel" short:"l" description:"Set the logging level"`
-	LogFileName  string `long:"logfilename" description:"Set the name of the log file"`
-	LogSize      int    `long:"logsize" description:"Set the size of each log files (MB)"`
-	LogAge       int    `long:"logage" description:"Set the time period to retain the log files (days)"`
-	LogBackup    int    `long:"logbackup" description:"Set the max number of log files to retain"`
-	LogCompress  bool   `long:"logcompress" description:"To compress the log files"`
-	MqttLogs     bool   `long:"mqttlogs" description:"For developer - Display detailed MQTT logging messages"`
-	Heartbeat    int    `long:"heartbeat" short:"t" description:"Heartbeat time in seconds" `
-	LogSendInvl  int    `long:"logsendinvl" description:"Time interval in sec to send edge app logs" `
-	Stdout       bool   `long:"out" description:"Print logs to stdout"`
-	ConfigPath   string `long:"config" description:"Path to the .json config file"`
-	ManifestPath string `long:"manifest" description:"Path to the .json manifest file"`
-	Delete       bool   `long:"delete" short:"d" description:"Remove node from beeta manager (when uninstalling the agent)"`
+	Version            bool    `long:"version" short:"v" description:"Print version information and exit"`
+	VerifyAudit        bool    `long:"verifyaudit" description:"Verify the hash chain of the audit log and exit"`
+	DataDir            string  `long:"data-dir" description:"Directory the agent keeps its state, keys, certificates and logs in"`
+	Broker             string  `long:"broker" short:"b" description:"Broker to connect"`
+	NodeId             string  `long:"id" short:"i" description:"ID of this node"`
+	NodeName           string  `long:"name" short:"n" description:"Name of this node to be registered"`
+	NoTLS              bool    `long:"notls" description:"For developer - disable TLS for MQTT"`
+	Password           string  `long:"password" description:"Password for TLS"`
+	RootCertPath       string  `long:"rootcert" description:"Path to MQTT broker (server) certificate"`
+	LogLevel           string  `long:"loglevel" short:"l" description:"Set the logging level"`
+	LogFileName        string  `long:"logfilename" description:"Set the name of the log file"`
+	AuditLogFile       string  `long:"auditlog" description:"Set the name of the audit log file"`
+	LogSize            int     `long:"logsize" description:"Set the size of each log files (MB)"`
+	LogAge             int     `long:"logage" description:"Set the time period to retain the log files (days)"`
+	LogBackup          int     `long:"logbackup" description:"Set the max number of log files to retain"`
+	LogCompress        bool    `long:"logcompress" description:"To compress the log files"`
+	MqttLogs           bool    `long:"mqttlogs" description:"For developer - Display detailed MQTT logging messages"`
+	Heartbeat          int     `long:"heartbeat" short:"t" description:"Heartbeat time in seconds" `
+	LogSendInvl        int     `long:"logsendinvl" description:"Time interval in sec to send edge app logs" `
+	PullRetries        *int    `long:"pullretries" description:"Number of times a failed image pull is retried (0 = no retries)"`
+	PullBackoff        int     `long:"pullbackoff" description:"Time to wait in sec before retrying a failed image pull, doubled on every retry"`
+	PullParallel       int     `long:"pullparallel" description:"Number of images pulled at the same time"`
+	PullMaxRate        int     `long:"pullmaxrate" description:"Max total download rate of image pulls (KB/s), needs the agent in the network namespace of the docker daemon"`
+	ImageGCInvl        int     `long:"imagegcinvl" description:"Time interval in sec to check if unused images have to be removed"`
+	ImageGCHigh        float64 `long:"imagegchigh" description:"Disk usage (%) above which unused images are removed"`
+	ImageGCLow         float64 `long:"imagegclow" description:"Disk usage (%) down to which unused images are removed"`
+	ImageGCKeep        int     `long:"imagegckeep" description:"Number of unused image versions to keep per repository"`
+	NodeKeyAlgorithm   string  `long:"nodekeyalgorithm" description:"Algorithm of newly generated node keys: rsa, ecdsa or ed25519"`
+	NodeKeyPath        string  `long:"nodekeypath" description:"Path to the node private key"`
+	ImageImportDir     string  `long:"imageimportdir" description:"Directory watched for image archives (docker save) to import"`
+	ImageImportInvl    int     `long:"imageimportinvl" description:"Time interval in sec to check the image import directory for new archives"`
+	ModuleMaxCPUs      float64 `long:"modulemaxcpus" description:"Max number of CPUs a single edge app module may use"`
+	ModuleMaxMemory    int64   `long:"modulemaxmemory" description:"Max memory a single edge app module may use (MB)"`
+	ModuleMaxPids      int64   `long:"modulemaxpids" description:"Max number of processes a single edge app module may run"`
+	NodeCPUCapacity    float64 `long:"nodecpus" description:"Number of CPUs of the node available to edge apps (default: all)"`
+	NodeMemoryCapacity int64   `long:"nodememory" description:"Memory of the node available to edge apps (MB) (default: all)"`
+	NodeDiskCapacity   int64   `long:"nodedisk" description:"Disk space of the node available to edge apps (MB) (default: all)"`
+	ModuleDefaultCPUs  float64 `long:"moduledefaultcpus" description:"Number of CPUs reserved for a module that does not request CPUs"`
+	ModuleDefaultMem   int64   `long:"moduledefaultmemory" description:"Memory reserved for a module that does not request memory (MB)"`
+	Stdout             bool    `long:"out" description:"Print logs to stdout"`
+	ConfigPath         string  `long:"config" description:"Path to the .json config file"`
+	ManifestPath       string  `long:"manifest" description:"Path to the .json manifest file"`
+	Delete             bool    `long:"delete" short:"d" description:"Remove node from beeta manager (when uninstalling the agent)"`
 }
 
 type ManifestUniqueID struct {
@@ -55,6 +78,7 @@ const (
 	EdgeAppInitiated  = "Initiated"
 	EdgeAppExecuting  = "Executing"
 	EdgeAppUndeployed = "Undeployed"
+	EdgeAppStaged     = "Staged"
 )
 
 const (
@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const (
+	dockerHubDomain    = "docker.io"
+	dockerHubServer    = "https://index.docker.io/v1/"
+	helperTimeout      = 30 * time.Second
+	helperTokenUser    = "<token>"
+	credentialNotFound = "credentials not found"
+)
+
+// helperCredential is the output of a docker credential helper
+type helperCredential struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// ResolveAuthConfig returns the credentials to pull the image with. A credential referenced by name from the
+// credential store comes first, then the credentials embedded in the manifest and finally the credential helper
+// configured for the registry of the image. Without any of them the image is pulled anonymously.
+func ResolveAuthConfig(credentialName string, imageName string, authConfig types.AuthConfig) (types.AuthConfig, error) {
+	domain, err := imageDomain(imageName)
+	if err != nil {
+		return types.AuthConfig{}, traceutility.Wrap(err)
+	}
+
+	if credentialName != "" {
+		cred, err := getCredential(credentialName)
+		if err != nil {
+			return types.AuthConfig{}, traceutility.Wrap(err)
+		}
+		// never send the credentials of one registry to another one
+		if normalizeDomain(cred.Registry) != domain {
+			return types.AuthConfig{}, fmt.Errorf("registry credential %v is for %v, not for the registry %v of image %v",
+				credentialName, cred.Registry, domain, imageName)
+		}
+
+		return types.AuthConfig{
+			ServerAddress: serverAddress(domain),
+			Username:      cred.UserName,
+			Password:      cred.Password,
+			IdentityToken: cred.IdentityToken,
+			RegistryToken: cred.RegistryToken,
+		}, nil
+	}
+
+	if authConfig.Username != "" || authConfig.Password != "" {
+		return authConfig, nil
+	}
+
+	helper := credentialHelper(domain)
+	if helper == "" {
+		return authConfig, nil
+	}
+
+	helperAuthConfig, err := getHelperCredential(helper, serverAddress(domain))
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("credential helper %v failed for %v: %w", helper, domain, err)
+	}
+	if helperAuthConfig == nil {
+		log.Debugf("Credential helper %v has no credentials for %v, pulling anonymously", helper, domain)
+		return authConfig, nil
+	}
+
+	return *helperAuthConfig, nil
+}
+
+// getHelperCredential runs docker-credential-<helper> get, it returns nil if the helper has no credentials for the server
+func getHelperCredential(helper string, server string) (*types.AuthConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(strings.ToLower(output), credentialNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: %v", err, output)
+	}
+
+	var cred helperCredential
+	err = json.Unmarshal(stdout.Bytes(), &cred)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	authConfig := types.AuthConfig{ServerAddress: server}
+	if cred.Username == helperTokenUser {
+		authConfig.IdentityToken = cred.Secret
+	} else {
+		authConfig.Username = cred.Username
+		authConfig.Password = cred.Secret
+	}
+
+	return &authConfig, nil
+}
+
+// credentialHelper returns the credential helper configured for the registry domain
+func credentialHelper(domain string) string {
+	for registry, helper := range config.Params.RegistryCredentialHelpers {
+		if normalizeDomain(registry) == domain {
+			return helper
+		}
+	}
+	return ""
+}
+
+func imageDomain(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+	return reference.Domain(named), nil
+}
+
+// normalizeDomain returns the domain of a registry given as host or URL, e.g. docker.io for https://index.docker.io/v1/
+func normalizeDomain(registry string) string {
+	domain := strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	domain, _, _ = strings.Cut(domain, "/")
+	if domain == "index.docker.io" || domain == "registry-1.docker.io" {
+		return dockerHubDomain
+	}
+	return domain
+}
+
+func serverAddress(domain string) string {
+	if domain == dockerHubDomain {
+		return dockerHubServer
+	}
+	return domain
+}
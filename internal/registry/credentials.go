@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/com"
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/secret"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const CredentialStoreFile = "registry_credentials.json"
+const sealLabel = "registryCredentials"
+
+// Credential is a named set of credentials for a registry, either a user name and password
+// or a (possibly short-lived) identity or registry token
+type Credential struct {
+	Name          string
+	Registry      string // registry host, e.g. registry.example.com:5000
+	UserName      string
+	Password      string
+	IdentityToken string
+	RegistryToken string
+	ExpiresAt     time.Time // zero if the credential does not expire
+}
+
+func (c Credential) expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+// credentialMsg is a credential as sent by the manager, the secret values are encrypted with the organization key
+type credentialMsg struct {
+	Name          string
+	Registry      string
+	UserName      string
+	Password      string
+	IdentityToken string
+	RegistryToken string
+	ExpiresAt     time.Time
+}
+
+type credentialsMsg struct {
+	Credentials []credentialMsg
+	Removed     []string
+}
+
+var credentials = make(map[string]Credential)
+var credentialsLock sync.Mutex
+
+// InitCredentialStore reads the credentials sealed with the node key from the credential store
+func InitCredentialStore() error {
+	sealed, err := os.ReadFile(config.StatePath(CredentialStoreFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return traceutility.Wrap(err)
+	}
+
+	plaintext, err := secret.Unseal(sealed, sealLabel)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+
+	err = json.Unmarshal(plaintext, &credentials)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	log.Infof("Loaded %v registry credentials", len(credentials))
+	return nil
+}
+
+// ProcessCredentialsMessage adds, replaces and removes the credentials of the message and persists the store
+func ProcessCredentialsMessage(payload []byte) error {
+	var msg credentialsMsg
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	var received []Credential
+	for _, credMsg := range msg.Credentials {
+		cred, err := decryptCredential(credMsg)
+		if err != nil {
+			return fmt.Errorf("invalid registry credential %v: %w", credMsg.Name, err)
+		}
+		received = append(received, cred)
+	}
+
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+
+	for _, cred := range received {
+		credentials[cred.Name] = cred
+		log.Info("Registry credential set: ", cred.Name)
+	}
+	for _, name := range msg.Removed {
+		delete(credentials, name)
+		log.Info("Registry credential removed: ", name)
+	}
+
+	return writeCredentialStore()
+}
+
+func decryptCredential(msg credentialMsg) (Credential, error) {
+	if msg.Name == "" || msg.Registry == "" {
+		return Credential{}, errors.New("name and registry are required")
+	}
+
+	cred := Credential{
+		Name:      msg.Name,
+		Registry:  msg.Registry,
+		UserName:  msg.UserName,
+		ExpiresAt: msg.ExpiresAt,
+	}
+
+	var err error
+	for _, field := range []struct {
+		encrypted string
+		decrypted *string
+	}{
+		{msg.Password, &cred.Password},
+		{msg.IdentityToken, &cred.IdentityToken},
+		{msg.RegistryToken, &cred.RegistryToken},
+	} {
+		if field.encrypted == "" {
+			continue
+		}
+		*field.decrypted, err = secret.DecryptEnv(field.encrypted)
+		if err != nil {
+			return Credential{}, traceutility.Wrap(err)
+		}
+	}
+
+	return cred, nil
+}
+
+// writeCredentialStore seals the credentials with the node key and writes them to the credential store, the caller holds the lock
+func writeCredentialStore() error {
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	sealed, err := secret.Seal(plaintext, sealLabel)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return os.WriteFile(config.StatePath(CredentialStoreFile), sealed, 0600)
+}
+
+func getCredential(name string) (Credential, error) {
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+
+	cred, ok := credentials[name]
+	if !ok {
+		return Credential{}, fmt.Errorf("registry credential %v is not known on the node", name)
+	}
+	if cred.expired() {
+		return Credential{}, fmt.Errorf("registry credential %v expired at %v", name, cred.ExpiresAt)
+	}
+
+	return cred, nil
+}
+
+// GetCredentialStatus returns the stored credentials without their secret values
+func GetCredentialStatus() []com.RegistryCredentialMsg {
+	credentialsLock.Lock()
+	defer credentialsLock.Unlock()
+
+	status := []com.RegistryCredentialMsg{}
+	for _, cred := range credentials {
+		msg := com.RegistryCredentialMsg{
+			Name:     cred.Name,
+			Registry: cred.Registry,
+			Expired:  cred.expired(),
+		}
+		if !cred.ExpiresAt.IsZero() {
+			expiresAt := cred.ExpiresAt
+			msg.ExpiresAt = &expiresAt
+		}
+		status = append(status, msg)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+
+	return status
+}
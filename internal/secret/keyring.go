@@ -0,0 +1,234 @@
+package secret
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const defaultKeyIDLength = 16
+
+const OrgKeysFile = "orgKeys.json"
+const orgKeysSealLabel = "orgKeys"
+
+// orgKey is an organization key held by the node, keys replaced by a newer one are retired
+// and kept for the grace period, so that manifests encrypted with them can still be decrypted
+type orgKey struct {
+	id        string
+	hash      string
+	key       []byte
+	aead      cipher.AEAD
+	retiredAt time.Time // zero for the current key
+}
+
+// orgKeys holds the current org key first, followed by the retired ones
+var orgKeys []*orgKey
+var orgKeysLock sync.Mutex
+
+// addOrgKey makes the key the current org key and retires the previous one
+func addOrgKey(keyID string, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(key))
+	if keyID == "" {
+		keyID = hash[:defaultKeyIDLength]
+	}
+
+	orgKeysLock.Lock()
+	defer orgKeysLock.Unlock()
+
+	now := time.Now()
+	keys := []*orgKey{{id: keyID, hash: hash, key: key, aead: aead}}
+	for _, k := range orgKeys {
+		// the same key sent again or a key replaced under the same ID
+		if k.hash == hash || k.id == keyID {
+			continue
+		}
+		if k.retiredAt.IsZero() {
+			k.retiredAt = now
+		}
+		keys = append(keys, k)
+	}
+	orgKeys = keys
+	pruneOrgKeys(now)
+
+	return writeOrgKeys()
+}
+
+// pruneOrgKeys drops the retired keys whose grace period is over, the caller holds the lock
+func pruneOrgKeys(now time.Time) {
+	grace := time.Second * time.Duration(config.Params.OrgKeyGracePeriod)
+
+	var keys []*orgKey
+	for _, k := range orgKeys {
+		if k.retiredAt.IsZero() || now.Sub(k.retiredAt) < grace {
+			keys = append(keys, k)
+		}
+	}
+
+	if len(keys) < len(orgKeys) {
+		orgKeys = keys
+		err := writeOrgKeys()
+		if err != nil {
+			log.Error("Failed to write org keys to file! CAUSE --> ", err)
+		}
+	}
+}
+
+// storedOrgKey is an org key as written to the org keys file, which is sealed with the node key as a whole
+type storedOrgKey struct {
+	ID        string
+	Key       []byte
+	RetiredAt time.Time
+}
+
+// writeOrgKeys persists the org keys sealed with the node key, so that they survive a restart, the caller holds the lock
+func writeOrgKeys() error {
+	var stored []storedOrgKey
+	for _, k := range orgKeys {
+		stored = append(stored, storedOrgKey{ID: k.id, Key: k.key, RetiredAt: k.retiredAt})
+	}
+
+	plaintext, err := json.Marshal(stored)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	sealed, err := Seal(plaintext, orgKeysSealLabel)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	return os.WriteFile(config.KeyPath(OrgKeysFile), sealed, 0600)
+}
+
+// loadOrgKeys restores the org keys written by writeOrgKeys
+func loadOrgKeys() error {
+	sealed, err := os.ReadFile(config.KeyPath(OrgKeysFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return traceutility.Wrap(err)
+	}
+
+	plaintext, err := Unseal(sealed, orgKeysSealLabel)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	var stored []storedOrgKey
+	err = json.Unmarshal(plaintext, &stored)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	var keys []*orgKey
+	for _, s := range stored {
+		aead, err := newAEAD(s.Key)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		keys = append(keys, &orgKey{
+			id:        s.ID,
+			hash:      fmt.Sprintf("%x", sha256.Sum256(s.Key)),
+			key:       s.Key,
+			aead:      aead,
+			retiredAt: s.RetiredAt,
+		})
+	}
+
+	orgKeysLock.Lock()
+	defer orgKeysLock.Unlock()
+
+	orgKeys = keys
+	pruneOrgKeys(time.Now())
+	log.Infof("Restored %v org keys.", len(orgKeys))
+
+	return nil
+}
+
+// DecryptEnv decrypts a value encrypted with an org key. The ciphertext is <keyID>:<base64>, values without
+// a key ID (encrypted before key IDs were introduced) are tried with all held keys.
+func DecryptEnv(enc string) (string, error) {
+	keyID, encoded, hasKeyID := strings.Cut(enc, ":") // base64 never contains a colon
+	if !hasKeyID {
+		encoded = enc
+	}
+
+	encBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", traceutility.Wrap(err)
+	}
+
+	orgKeysLock.Lock()
+	defer orgKeysLock.Unlock()
+
+	pruneOrgKeys(time.Now())
+	if len(orgKeys) == 0 {
+		return "", errors.New("don't have org's private key. cannot decrypt")
+	}
+
+	var lastErr error
+	for _, k := range orgKeys {
+		if hasKeyID && k.id != keyID {
+			continue
+		}
+
+		if len(encBytes) < k.aead.NonceSize() {
+			return "", errors.New("encrypted value is too short")
+		}
+		nonce, ciphertext := encBytes[:k.aead.NonceSize()], encBytes[k.aead.NonceSize():]
+
+		plaintext, err := k.aead.Open(nil, nonce, ciphertext, nil)
+		if err == nil {
+			return string(plaintext), nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		return "", fmt.Errorf("don't have org's private key %v. cannot decrypt", keyID)
+	}
+	return "", traceutility.Wrap(lastErr)
+}
+
+// GetOrgKeyHash returns the hash of the current org key, or an empty string if the node has none
+func GetOrgKeyHash() string {
+	orgKeysLock.Lock()
+	defer orgKeysLock.Unlock()
+
+	if len(orgKeys) == 0 {
+		return ""
+	}
+	return orgKeys[0].hash
+}
+
+// GetOrgKeyHashes returns the hashes of all org keys held by the node, the current one first
+func GetOrgKeyHashes() []string {
+	orgKeysLock.Lock()
+	defer orgKeysLock.Unlock()
+
+	pruneOrgKeys(time.Now())
+
+	hashes := []string{}
+	for _, k := range orgKeys {
+		hashes = append(hashes, k.hash)
+	}
+	return hashes
+}
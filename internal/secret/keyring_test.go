@@ -0,0 +1,150 @@
+package secret_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/secret"
+)
+
+func TestOrgKeyRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+	config.Params.OrgKeyGracePeriod = 60
+
+	nodePublicKey := initNodeKeypair(t)
+
+	oldKey := sendOrgKey(t, nodePublicKey, "old")
+	oldValue := encryptEnv(t, oldKey, "old", "value")
+
+	newKey := sendOrgKey(t, nodePublicKey, "new")
+	newValue := encryptEnv(t, newKey, "new", "value")
+	legacyValue := encryptEnv(t, oldKey, "", "value")
+
+	for _, enc := range []string{oldValue, newValue, legacyValue} {
+		value, err := secret.DecryptEnv(enc)
+		assert.Nil(err)
+		assert.Equal("value", value)
+	}
+
+	assert.Len(secret.GetOrgKeyHashes(), 2)
+	assert.Equal(secret.GetOrgKeyHashes()[0], secret.GetOrgKeyHash())
+
+	_, err := secret.DecryptEnv(encryptEnv(t, oldKey, "unknown", "value"))
+	assert.NotNil(err)
+
+	// the old key is dropped once its grace period is over
+	config.Params.OrgKeyGracePeriod = 0
+	_, err = secret.DecryptEnv(oldValue)
+	assert.NotNil(err)
+	assert.Len(secret.GetOrgKeyHashes(), 1)
+}
+
+func TestOrgKeyPersistence(t *testing.T) {
+	assert := assert.New(t)
+
+	nodePublicKey := initNodeKeypair(t)
+	orgKey := sendOrgKey(t, nodePublicKey, "persisted")
+
+	stored, err := os.ReadFile("orgKeys.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(string(stored), base64.StdEncoding.EncodeToString(orgKey))
+
+	// restart of the agent
+	_, err = secret.InitNodeKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := secret.DecryptEnv(encryptEnv(t, orgKey, "persisted", "value"))
+	assert.Nil(err)
+	assert.Equal("value", value)
+}
+
+// initNodeKeypair creates the node keypair in a temporary working directory and returns the node public key
+func initNodeKeypair(t *testing.T) *rsa.PublicKey {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKeyPem, err := secret.InitNodeKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(publicKeyPem)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return publicKey.(*rsa.PublicKey)
+}
+
+// sendOrgKey sends a new org key to the node the way the manager does and returns the key
+func sendOrgKey(t *testing.T, nodePublicKey *rsa.PublicKey, keyID string) []byte {
+	orgKey := make([]byte, 32)
+	if _, err := rand.Read(orgKey); err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedOrgKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, nodePublicKey, orgKey, []byte("orgKey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"encryptedOrgKey": base64.StdEncoding.EncodeToString(encryptedOrgKey),
+		"keyID":           keyID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := secret.ProcessOrgPrivKeyMessage(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return orgKey
+}
+
+func encryptEnv(t *testing.T, orgKey []byte, keyID string, value string) string {
+	block, err := aes.NewCipher(orgKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	enc := base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil))
+	if keyID != "" {
+		enc = keyID + ":" + enc
+	}
+	return enc
+}
@@ -0,0 +1,198 @@
+package secret
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const (
+	KeyAlgorithmRSA     = "rsa"
+	KeyAlgorithmECDSA   = "ecdsa"
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
+// generateKey creates a node private key of the given algorithm
+func generateKey(algorithm string) (crypto.PrivateKey, error) {
+	switch algorithm {
+	case KeyAlgorithmRSA:
+		return rsa.GenerateKey(rand.Reader, keySize)
+	case KeyAlgorithmECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported node key algorithm %v", algorithm)
+	}
+}
+
+// readKeyFile reads a PEM encoded node private key, RSA keys in PKCS #1 and all keys in PKCS #8 form
+func readKeyFile(path string) (crypto.PrivateKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing private key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block type %v for private key", block.Type)
+	}
+}
+
+// writeKeyFile writes the node private key readable by the agent only
+func writeKeyFile(path string, key crypto.PrivateKey) error {
+	var block *pem.Block
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return traceutility.Wrap(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	return os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+}
+
+// publicKeyPem returns the PEM encoded (PKIX) public key of the node private key
+func publicKeyPem(key crypto.PrivateKey) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported node key type")
+	}
+
+	pk, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	publicKeyPemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pk})
+	if publicKeyPemBytes == nil {
+		return nil, errors.New("failed to encode PEM block containing public key")
+	}
+	return publicKeyPemBytes, nil
+}
+
+// ecdhKey returns the key agreement key of an ECDSA or Ed25519 node key.
+// For Ed25519 this is the X25519 key derived from the same seed, as done by libsodium.
+func ecdhKey(key crypto.PrivateKey) (*ecdh.PrivateKey, error) {
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key.ECDH()
+	case ed25519.PrivateKey:
+		h := sha512.Sum512(key.Seed())
+		return ecdh.X25519().NewPrivateKey(h[:32])
+	default:
+		return nil, errors.New("unsupported node key type")
+	}
+}
+
+// encryptToKey encrypts the plaintext for the node key. RSA keys use RSA-OAEP with the label,
+// ECDSA and Ed25519 keys use ECIES: an ephemeral ECDH key, HKDF-SHA256 and AES-GCM,
+// the result being the ephemeral public key followed by the nonce and the ciphertext.
+func encryptToKey(key crypto.PrivateKey, plaintext []byte, label []byte) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, &rsaKey.PublicKey, plaintext, label)
+	}
+
+	recipient, err := ecdhKey(key)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	shared, err := ephemeral.ECDH(recipient.PublicKey())
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	aead, err := eciesAEAD(shared, ephemeral.PublicKey().Bytes(), label)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	out := append(ephemeral.PublicKey().Bytes(), nonce...)
+	return aead.Seal(out, nonce, plaintext, label), nil
+}
+
+// decryptWithKey decrypts a ciphertext created by encryptToKey
+func decryptWithKey(key crypto.PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, ciphertext, label)
+	}
+
+	recipient, err := ecdhKey(key)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	// the ephemeral key is on the same curve, so its public key has the same length
+	keyLen := len(recipient.PublicKey().Bytes())
+	if len(ciphertext) < keyLen {
+		return nil, errors.New("ciphertext is too short")
+	}
+	ephemeral, err := recipient.Curve().NewPublicKey(ciphertext[:keyLen])
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+	shared, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	aead, err := eciesAEAD(shared, ciphertext[:keyLen], label)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	rest := ciphertext[keyLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], label)
+}
+
+// eciesAEAD derives the AES-256-GCM cipher from the ECDH shared secret, salted with the ephemeral public key
+func eciesAEAD(shared []byte, ephemeralPublicKey []byte, label []byte) (cipher.AEAD, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, ephemeralPublicKey, label), key); err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
@@ -0,0 +1,118 @@
+package secret_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	"github.com/beetaone/beeta-agent/internal/secret"
+)
+
+func TestRotateNodeKey(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(params config.ParamStruct) { config.Params = params }(config.Params)
+	config.Params.NodeKeyAlgorithm = "rsa"
+
+	nodePublicKey := initNodeKeypair(t)
+	sendOrgKey(t, nodePublicKey, "")
+
+	sealed, err := secret.Seal([]byte("top secret"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Params.NodeKeyAlgorithm = "ecdsa"
+	newPublicKeyPem, err := secret.RotateNodeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(secret.IsNodeKeyRotationPending())
+
+	block, _ := pem.Decode(newPublicKeyPem)
+	newPublicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the manager encrypts the org key to the new node key, which completes the rotation
+	orgKey := make([]byte, 32)
+	if _, err := rand.Read(orgKey); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]string{
+		"encryptedOrgKey": base64.StdEncoding.EncodeToString(eciesEncrypt(t, newPublicKey.(*ecdsa.PublicKey), orgKey, "orgKey")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(secret.ProcessOrgPrivKeyMessage(payload))
+	assert.False(secret.IsNodeKeyRotationPending())
+
+	// restart of the agent with the new key
+	publicKeyPem, err := secret.InitNodeKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(newPublicKeyPem, publicKeyPem)
+	_, err = os.Stat(config.Params.NodeKeyPath + ".new")
+	assert.True(os.IsNotExist(err))
+
+	plaintext, err := secret.Unseal(sealed, "test")
+	assert.Nil(err)
+	assert.Equal("top secret", string(plaintext))
+
+	value, err := secret.DecryptEnv(encryptEnv(t, orgKey, "", "value"))
+	assert.Nil(err)
+	assert.Equal("value", value)
+}
+
+// eciesEncrypt encrypts to an ECDSA node key the way the manager does
+func eciesEncrypt(t *testing.T, publicKey *ecdsa.PublicKey, plaintext []byte, label string) []byte {
+	recipient, err := publicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, ephemeral.PublicKey().Bytes(), []byte(label)), key); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	out := append(ephemeral.PublicKey().Bytes(), nonce...)
+	return aead.Seal(out, nonce, plaintext, []byte(label))
+}
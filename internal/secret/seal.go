@@ -0,0 +1,140 @@
+package secret
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/beetaone/beeta-agent/internal/config"
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+const dataKeySize = 32
+const SealKeyFile = "nodeSealKey.bin"
+const sealKeyLabel = "sealKey"
+
+// sealKey encrypts the data sealed on the node. It is stored wrapped with the node key,
+// so that only the seal key has to be wrapped again when the node key is rotated.
+var sealKey []byte
+
+// initSealKey unwraps the seal key with the node key or creates it on the first start, the caller holds the node key lock
+func initSealKey() error {
+	wrapped, err := os.ReadFile(config.KeyPath(SealKeyFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return traceutility.Wrap(err)
+		}
+
+		key := make([]byte, dataKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return traceutility.Wrap(err)
+		}
+		sealKey = key
+		return writeSealKey(nodePrivateKey)
+	}
+
+	key, err := decryptWithKey(nodePrivateKey, wrapped, []byte(sealKeyLabel))
+	if err == nil {
+		sealKey = key
+		return nil
+	}
+
+	if pendingNodeKey != nil {
+		key, pendingErr := decryptWithKey(pendingNodeKey, wrapped, []byte(sealKeyLabel))
+		if pendingErr == nil {
+			log.Info("Completing the interrupted node key rotation...")
+			sealKey = key
+			return promotePendingNodeKey()
+		}
+	}
+
+	return traceutility.Wrap(err)
+}
+
+// writeSealKey stores the seal key wrapped with the node key
+func writeSealKey(nodeKey crypto.PrivateKey) error {
+	wrapped, err := encryptToKey(nodeKey, sealKey, []byte(sealKeyLabel))
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	sealKeyFile := config.KeyPath(SealKeyFile)
+	tmpFile := sealKeyFile + ".tmp"
+	err = os.WriteFile(tmpFile, wrapped, 0600)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+	return os.Rename(tmpFile, sealKeyFile)
+}
+
+// Seal encrypts the plaintext with the seal key of the node, so that it can be stored on the node.
+// The label binds the sealed data to its purpose, it has to be passed to Unseal again.
+func Seal(plaintext []byte, label string) ([]byte, error) {
+	if sealKey == nil {
+		return nil, errors.New("node keypair is not initialized")
+	}
+
+	aead, err := newAEAD(sealKey)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, []byte(label)), nil
+}
+
+// Unseal decrypts data encrypted by Seal with the same label
+func Unseal(sealed []byte, label string) ([]byte, error) {
+	if sealKey == nil {
+		return nil, errors.New("node keypair is not initialized")
+	}
+
+	aead, err := newAEAD(sealKey)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(label))
+	if err != nil {
+		return nil, traceutility.Wrap(err)
+	}
+
+	return plaintext, nil
+}
+
+// DeriveKey returns a key for the purpose given by the label, derived from the seal key with HMAC-SHA256.
+// It survives node key rotations like the seal key.
+func DeriveKey(label string) ([]byte, error) {
+	if sealKey == nil {
+		return nil, errors.New("node keypair is not initialized")
+	}
+
+	mac := hmac.New(sha256.New, sealKey)
+	mac.Write([]byte(label))
+	return mac.Sum(nil), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,28 @@
+package secret_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/secret"
+)
+
+func TestSeal(t *testing.T) {
+	assert := assert.New(t)
+
+	initNodeKeypair(t)
+
+	sealed, err := secret.Seal([]byte("top secret"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(string(sealed), "top secret")
+
+	plaintext, err := secret.Unseal(sealed, "test")
+	assert.Nil(err)
+	assert.Equal("top secret", string(plaintext))
+
+	_, err = secret.Unseal(sealed, "other")
+	assert.NotNil(err)
+}
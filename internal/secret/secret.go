@@ -1,111 +1,154 @@
 package secret
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
-	"fmt"
-	"io"
 	"os"
-
-	"errors"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/beetaone/beeta-agent/internal/config"
 	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
 )
 
 const keySize = 2048
-const nodePrivateKeyFile = "nodePrivateKey.pem"
+const orgKeyLabel = "orgKey"
 
 type orgPrivKeyMsg struct {
 	EncryptedOrgKey string
+	KeyID           string // optional, defaults to the beginning of the key hash
 }
 
-var nodePrivateKey *rsa.PrivateKey
-var decryptor cipher.AEAD
-var OrgKeyHash string
+var nodePrivateKey crypto.PrivateKey
+
+// pendingNodeKey is the new node key of a rotation, it replaces the current key once the manager
+// sent the org key encrypted to it
+var pendingNodeKey crypto.PrivateKey
+var nodeKeyLock sync.Mutex
 
 func InitNodeKeypair() ([]byte, error) {
 	log.Debug("Initializing node keypair...")
 
-	pemFile, err := os.Open(nodePrivateKeyFile)
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+
+	key, err := readKeyFile(config.Params.NodeKeyPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Info("No node private key found. Generating...")
-			err := generateNodeKeypair()
-			if err != nil {
-				return nil, traceutility.Wrap(err)
-			}
-		} else {
+		if !os.IsNotExist(err) {
 			return nil, traceutility.Wrap(err)
 		}
-	} else {
-		log.Info("Node private key found.")
-		defer pemFile.Close()
 
-		byteValue, err := io.ReadAll(pemFile)
+		log.Info("No node private key found. Generating...")
+		key, err = generateKey(config.Params.NodeKeyAlgorithm)
 		if err != nil {
 			return nil, traceutility.Wrap(err)
 		}
-
-		block, _ := pem.Decode(byteValue)
-		if block == nil || block.Type != "RSA PRIVATE KEY" {
-			return nil, errors.New("failed to decode PEM block containing private key")
+		err = writeKeyFile(config.Params.NodeKeyPath, key)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
 		}
+	} else {
+		log.Info("Node private key found.")
+	}
+	nodePrivateKey = key
 
-		// add org private key to node
-		nodePrivateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
+	pendingNodeKey, err = readKeyFile(pendingNodeKeyPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
 			return nil, traceutility.Wrap(err)
 		}
+		pendingNodeKey = nil
+	} else {
+		log.Info("Node key rotation is pending.")
 	}
 	log.Info("Node private key set.")
-	log.Info("Generating node public key...")
 
-	pk, err := x509.MarshalPKIXPublicKey(&nodePrivateKey.PublicKey)
+	err = initSealKey()
 	if err != nil {
 		return nil, traceutility.Wrap(err)
 	}
 
-	// return public key
-	publicKeyPem := &pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pk,
+	// the org keys are sealed with the node key, so they can only be restored now
+	err = loadOrgKeys()
+	if err != nil {
+		log.Warn("Restoring org keys failed, waiting for the manager to send them. CAUSE --> ", err)
 	}
-	publicKeyPemBytes := pem.EncodeToMemory(publicKeyPem)
-	if publicKeyPemBytes == nil {
-		return nil, errors.New("failed to encode PEM block containing public key")
+
+	log.Info("Generating node public key...")
+
+	// during a rotation the manager has to encrypt the org key to the new key
+	publicKey := nodePrivateKey
+	if pendingNodeKey != nil {
+		publicKey = pendingNodeKey
+	}
+	publicKeyPemBytes, err := publicKeyPem(publicKey)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
 	}
 
 	log.Debug("Generated node public key:\n", string(publicKeyPemBytes))
 	return publicKeyPemBytes, nil
 }
 
-func generateNodeKeypair() error {
-	var err error
-	nodePrivateKey, err = rsa.GenerateKey(rand.Reader, keySize)
+// RotateNodeKey generates a new node key with the configured algorithm and returns its public key.
+// The current key stays in use until the manager sends the org key encrypted to the new key.
+func RotateNodeKey() ([]byte, error) {
+	log.Info("Rotating node key...")
+
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+
+	key, err := generateKey(config.Params.NodeKeyAlgorithm)
 	if err != nil {
-		return traceutility.Wrap(err)
+		return nil, traceutility.Wrap(err)
 	}
 
-	// dump private key to file
-	privateKeyPem := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(nodePrivateKey),
+	err = writeKeyFile(pendingNodeKeyPath(), key)
+	if err != nil {
+		return nil, traceutility.Wrap(err)
 	}
+	pendingNodeKey = key
 
-	privateKeyFile, err := os.OpenFile(nodePrivateKeyFile, os.O_WRONLY|os.O_CREATE, 0600)
+	return publicKeyPem(key)
+}
+
+// completeNodeKeyRotation replaces the node key by the pending key, the caller holds the lock
+func completeNodeKeyRotation() error {
+	// the seal key is wrapped with the new key first, an interrupted rotation is completed by initSealKey
+	err := writeSealKey(pendingNodeKey)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
-	return pem.Encode(privateKeyFile, privateKeyPem)
+
+	return promotePendingNodeKey()
+}
+
+// promotePendingNodeKey makes the pending key the node key, the caller holds the lock
+func promotePendingNodeKey() error {
+	err := os.Rename(pendingNodeKeyPath(), config.Params.NodeKeyPath)
+	if err != nil {
+		return traceutility.Wrap(err)
+	}
+
+	nodePrivateKey = pendingNodeKey
+	pendingNodeKey = nil
+	log.Info("Node key rotation completed.")
+
+	return nil
+}
+
+// IsNodeKeyRotationPending reports if the manager has not yet sent the org key encrypted to the new node key
+func IsNodeKeyRotationPending() bool {
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+
+	return pendingNodeKey != nil
+}
+
+func pendingNodeKeyPath() string {
+	return config.Params.NodeKeyPath + ".new"
 }
 
 func ProcessOrgPrivKeyMessage(payload []byte) error {
@@ -121,42 +164,40 @@ func ProcessOrgPrivKeyMessage(payload []byte) error {
 		return traceutility.Wrap(err)
 	}
 
-	label := []byte("orgKey")
-	orgSecretKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, nodePrivateKey, encryptedOrgKey, label)
+	orgSecretKey, err := decryptOrgKey(encryptedOrgKey)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
 
-	block, err := aes.NewCipher(orgSecretKey)
+	err = addOrgKey(orgPrivKeyMessage.KeyID, orgSecretKey)
 	if err != nil {
 		return traceutility.Wrap(err)
 	}
 
-	decryptor, err = cipher.NewGCM(block)
-	if err != nil {
-		return traceutility.Wrap(err)
-	}
-
-	OrgKeyHash = fmt.Sprintf("%x", sha256.Sum256(orgSecretKey))
-
 	log.Info("Orga's private key set.")
 	return nil
 }
 
-func DecryptEnv(enc string) (string, error) {
-	if decryptor == nil {
-		return "", errors.New("don't have org's private key. cannot decrypt")
-	}
-	encBytes, err := base64.StdEncoding.DecodeString(enc)
-	if err != nil {
-		return "", traceutility.Wrap(err)
+// decryptOrgKey decrypts the org key with the pending node key, which completes a rotation, or with the current node key
+func decryptOrgKey(encryptedOrgKey []byte) ([]byte, error) {
+	nodeKeyLock.Lock()
+	defer nodeKeyLock.Unlock()
+
+	if pendingNodeKey != nil {
+		orgSecretKey, err := decryptWithKey(pendingNodeKey, encryptedOrgKey, []byte(orgKeyLabel))
+		if err == nil {
+			// the manager encrypts the org key to the new node key, so the old key is not needed anymore
+			err = completeNodeKeyRotation()
+			if err != nil {
+				log.Error("Completing the node key rotation failed! CAUSE --> ", err)
+			}
+			return orgSecretKey, nil
+		}
 	}
-	nonce, ciphertext := encBytes[:decryptor.NonceSize()], encBytes[decryptor.NonceSize():]
 
-	plaintext, err := decryptor.Open(nil, nonce, ciphertext, nil)
+	orgSecretKey, err := decryptWithKey(nodePrivateKey, encryptedOrgKey, []byte(orgKeyLabel))
 	if err != nil {
-		return "", traceutility.Wrap(err)
+		return nil, traceutility.Wrap(err)
 	}
-
-	return string(plaintext), nil
+	return orgSecretKey, nil
 }
@@ -0,0 +1,73 @@
+package secret
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	traceutility "github.com/beetaone/beeta-agent/internal/utility/trace"
+)
+
+// LoadPublicKeys reads PEM encoded (PKIX) ECDSA, Ed25519 or RSA public keys from the files
+func LoadPublicKeys(paths []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+
+	for _, path := range paths {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+
+		block, _ := pem.Decode(pemBytes)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("failed to decode PEM block containing public key in %v", path)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, traceutility.Wrap(err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// VerifySignature checks that the signature of the payload was made with one of the keys.
+// ECDSA and RSA signatures are expected over the SHA-256 hash of the payload, as created by cosign.
+func VerifySignature(keys []crypto.PublicKey, payload []byte, signature []byte) error {
+	if len(keys) == 0 {
+		return errors.New("no public keys to verify the signature with")
+	}
+
+	digest := sha256.Sum256(payload)
+
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], signature) {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, payload, signature) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+			if rsa.VerifyPSS(key, crypto.SHA256, digest[:], signature, nil) == nil {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("signature does not match any of the public keys")
+}
@@ -0,0 +1,69 @@
+package secret_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beetaone/beeta-agent/internal/secret"
+)
+
+func TestVerifySignature(t *testing.T) {
+	assert := assert.New(t)
+	payload := []byte("sha256:abababababababababababababababababababababababababababababababab")
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(payload)
+	ecdsaSignature, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed25519PublicKey, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Signature := ed25519.Sign(ed25519Key, payload)
+
+	keys := []crypto.PublicKey{&ecdsaKey.PublicKey, ed25519PublicKey}
+	assert.Nil(secret.VerifySignature(keys, payload, ecdsaSignature))
+	assert.Nil(secret.VerifySignature(keys, payload, ed25519Signature))
+	assert.NotNil(secret.VerifySignature(keys, []byte("sha256:other"), ecdsaSignature))
+	assert.NotNil(secret.VerifySignature(keys[1:], payload, ecdsaSignature))
+	assert.NotNil(secret.VerifySignature(nil, payload, ecdsaSignature))
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := secret.LoadPublicKeys([]string{path})
+	assert.Nil(err)
+	assert.Equal([]crypto.PublicKey{&key.PublicKey}, keys)
+}
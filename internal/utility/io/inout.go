@@ -23,3 +23,39 @@ func FirstToUpper(str string) string {
 	}
 	return strings.ToUpper(string(str[0])) + str[1:]
 }
+
+// WriteFileAtomic replaces the file with the data, so that a crash leaves either the old or the new content.
+// The data is written to a temporary file next to it, synced to disk and renamed over the file.
+func WriteFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmpFile := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	err = os.Rename(tmpFile, fileName)
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	// persist the rename itself
+	dir, err := os.Open(filepath.Dir(fileName))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
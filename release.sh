@@ -16,8 +16,9 @@ Priority: optional
 Homepage: https://beeta.one
 Description: A client to manage docker containers on IoT devices." >deb-${ARCH}/DEBIAN/control
 
-        mkdir -p deb-${ARCH}/var/lib/beeta-agent
-        cp ca.crt deb-${ARCH}/var/lib/beeta-agent
+        # the agent runs with --data-dir /var/lib/beeta-agent and reads the broker certificate from its certs directory
+        mkdir -p deb-${ARCH}/var/lib/beeta-agent/certs
+        cp ca.crt deb-${ARCH}/var/lib/beeta-agent/certs
 
         mkdir -p deb-${ARCH}/lib/systemd/system/
         cp beeta-agent.service deb-${ARCH}/lib/systemd/system
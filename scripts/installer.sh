@@ -16,7 +16,7 @@ print_logo() {
 
 print_execute_instruction() {
     echo "To execute the beeta-agent binary, run the following command:"
-    echo ""$BEETA_AGENT_DIR"/"$BINARY_NAME" --config "$CONFIG_FILE" 2>&1 &"
+    echo "sudo "$BEETA_AGENT_DIR"/"$BINARY_NAME" --config "$CONFIG_FILE" --data-dir "$DATA_DIR" 2>&1 &"
 }
 
 create_service_file() {
@@ -26,6 +26,9 @@ create_service_file() {
     log Copy the config file to /opt/beeta-agent
     sudo cp "$CONFIG_FILE" "$BEETA_AGENT_SERVICE_DIR"
 
+    log Creating the data directory $DATA_DIR
+    sudo mkdir -p "$DATA_DIR"
+
     log Creating the service file ...
     sudo tee "$SERVICE_FILE" >/dev/null <<EOF
 [Unit]
@@ -36,8 +39,8 @@ After=network.target
 Type=simple
 RestartSec=60s
 Restart=always
-WorkingDirectory=$BEETA_AGENT_SERVICE_DIR
-ExecStart=$BEETA_AGENT_SERVICE_DIR/$BINARY_NAME --config $BEETA_AGENT_SERVICE_DIR/$(basename $CONFIG_FILE) 2>&1
+WorkingDirectory=$DATA_DIR
+ExecStart=$BEETA_AGENT_SERVICE_DIR/$BINARY_NAME --config $BEETA_AGENT_SERVICE_DIR/$(basename $CONFIG_FILE) --data-dir $DATA_DIR 2>&1
 
 [Install]
 WantedBy=multi-user.target
@@ -59,6 +62,7 @@ LOG_FILE=installer.log
 BEETA_AGENT_DIR="$PWD/beeta-agent"
 SERVICE_FILE=/lib/systemd/system/beeta-agent.service
 BEETA_AGENT_SERVICE_DIR=/opt/beeta-agent
+DATA_DIR=/var/lib/beeta-agent
 BASE_DOWNLOAD_URL=https://file-service.theone.beeta.one/agent
 CONFIG_FILE="$1"
 
@@ -135,7 +139,6 @@ cleanup() {
 
     rm -rf "$LOG_FILE"
     log_warn Removed installer log file
-    rm -rf beeta_Agent.log
 
     if [ -f "$SERVICE_FILE" ]; then
 
@@ -155,22 +158,19 @@ cleanup() {
         sudo systemctl daemon-reload
         log_warn Reloaded the systemd manager configuration
 
-        log Backup known manifest and log files
-        sudo cp $BEETA_AGENT_SERVICE_DIR/known_manifests.jsonl /tmp/known_manifests.jsonl.bak
-        sudo cp $BEETA_AGENT_SERVICE_DIR/beeta_Agent.log /tmp/beeta_Agent.log.bak
+        # the agent moves the files of an agent that ran in the service directory into the data directory on startup
+        log Moving known manifest and log files to $DATA_DIR
+        sudo mkdir -p "$DATA_DIR"
+        for FILE in known_manifests.jsonl staged_manifests.jsonl beeta_Agent.log; do
+            if [ -f "$BEETA_AGENT_SERVICE_DIR/$FILE" ]; then
+                sudo mv "$BEETA_AGENT_SERVICE_DIR/$FILE" "$DATA_DIR/$FILE"
+            fi
+        done
 
         log_warn Removing the service directory ...
         sudo rm -rf "$BEETA_AGENT_SERVICE_DIR"
         log_warn Removed the service directory
-
-        log Restoring known manifest and log files
-        sudo mkdir -p $BEETA_AGENT_SERVICE_DIR
-        sudo cp /tmp/known_manifests.jsonl.bak $BEETA_AGENT_SERVICE_DIR/known_manifests.jsonl
-        sudo cp /tmp/beeta_Agent.log.bak $BEETA_AGENT_SERVICE_DIR/beeta_Agent.log
-
-        # log Cleaning temporary files
-        # rm -rf /tmp/known_manifests.jsonl.bak
-        # rm -rf /tmp/beeta_Agent.log.bak
+        sudo mkdir -p "$BEETA_AGENT_SERVICE_DIR"
 
     fi
 
@@ -182,7 +182,7 @@ post_installation() {
     echo "Please check the instalation logs at $LOG_FILE"
     empty_line
     echo "To see the beeta-agent logs, run the following command:"
-    echo "sudo tail -f $BEETA_AGENT_SERVICE_DIR/beeta_Agent.log"
+    echo "sudo tail -f $DATA_DIR/logs/beeta_Agent.log"
     empty_line
     echo "Cleaning installation files ..."
     rm -rf "$BEETA_AGENT_DIR"